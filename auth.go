@@ -4,12 +4,17 @@ import (
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"fmt"
+	"hash"
+	"strconv"
 	"strings"
 
-	"golang.org/x/crypto/pbkdf2"
+	"github.com/sausheong/pprox/internal/saslprep"
+	"github.com/sausheong/pprox/internal/scramkey"
 )
 
 // AuthConfig holds authentication configuration
@@ -17,27 +22,42 @@ type AuthConfig struct {
 	Users map[string]*UserCredentials
 }
 
-// UserCredentials stores user authentication information
+// UserCredentials stores user authentication information. Only the SCRAM
+// verifier is kept; the plaintext password is never retained.
 type UserCredentials struct {
 	Username       string
-	Password       string
 	Salt           []byte
 	StoredKey      []byte
 	ServerKey      []byte
 	IterationCount int
+	Attributes     UserAttributes // Per-user privileges
 }
 
 // SCRAMServer handles SCRAM-SHA-256 authentication
 type SCRAMServer struct {
-	user           *UserCredentials
-	clientNonce    string
-	serverNonce    string
-	clientFirstMsg string
-	serverFirstMsg string
-	authMessage    string
-	tlsState       *tls.ConnectionState
+	user            *UserCredentials
+	clientNonce     string
+	serverNonce     string
+	clientFirstBare string
+	serverFirstMsg  string
+	authMessage     string
+	mechanism       string // SCRAM-SHA-256 or SCRAM-SHA-256-PLUS
+	channelBinding  []byte // tls-server-end-point data, nil without TLS
+	gs2Header       string // GS2 header sent in the client-first-message
 }
 
+// SCRAM mechanism names
+const (
+	SCRAMSHA256     = "SCRAM-SHA-256"
+	SCRAMSHA256Plus = "SCRAM-SHA-256-PLUS"
+)
+
+// scramVerifierPrefix identifies a secret in PostgreSQL's SCRAM verifier format
+const scramVerifierPrefix = "SCRAM-SHA-256$"
+
+// defaultSCRAMIterations is the PBKDF2 iteration count for new verifiers, matching PostgreSQL
+const defaultSCRAMIterations = scramkey.DefaultIterations
+
 // NewAuthConfig creates a new authentication configuration
 func NewAuthConfig() *AuthConfig {
 	return &AuthConfig{
@@ -45,77 +65,133 @@ func NewAuthConfig() *AuthConfig {
 	}
 }
 
-// AddUser adds a user with SCRAM-SHA-256 credentials
-func (ac *AuthConfig) AddUser(username, password string) error {
-	salt := make([]byte, 16)
-	if _, err := rand.Read(salt); err != nil {
-		return fmt.Errorf("failed to generate salt: %w", err)
+// AddUser adds a user with SCRAM-SHA-256 credentials. The secret is either a
+// plaintext password or a verifier as stored in pg_authid:
+// SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>
+func (ac *AuthConfig) AddUser(username, secret string) error {
+	if IsSCRAMVerifier(secret) {
+		user, err := ParseSCRAMVerifier(secret)
+		if err != nil {
+			return err
+		}
+		user.Username = username
+		ac.Users[username] = user
+		return nil
 	}
 
-	iterationCount := 4096
+	salt, err := scramkey.NewSalt()
+	if err != nil {
+		return err
+	}
 
-	// Generate SCRAM credentials
-	saltedPassword := pbkdf2.Key([]byte(password), salt, iterationCount, 32, sha256.New)
-	
-	clientKeyHMAC := hmac.New(sha256.New, saltedPassword)
-	clientKeyHMAC.Write([]byte("Client Key"))
-	clientKey := clientKeyHMAC.Sum(nil)
-	
-	storedKeyHash := sha256.Sum256(clientKey)
-	storedKey := storedKeyHash[:]
-	
-	serverKeyHMAC := hmac.New(sha256.New, saltedPassword)
-	serverKeyHMAC.Write([]byte("Server Key"))
-	serverKey := serverKeyHMAC.Sum(nil)
+	// Clients normalize passwords with SASLprep before hashing them
+	storedKey, serverKey := scramkey.Derive(saslprep.Password(secret), salt, defaultSCRAMIterations)
 
 	ac.Users[username] = &UserCredentials{
 		Username:       username,
-		Password:       password,
 		Salt:           salt,
 		StoredKey:      storedKey,
 		ServerKey:      serverKey,
-		IterationCount: iterationCount,
+		IterationCount: defaultSCRAMIterations,
 	}
 
 	return nil
 }
 
+// IsSCRAMVerifier reports whether a secret is a SCRAM-SHA-256 verifier rather than a password
+func IsSCRAMVerifier(secret string) bool {
+	return strings.HasPrefix(secret, scramVerifierPrefix)
+}
+
+// ParseSCRAMVerifier parses a verifier in PostgreSQL's pg_authid format
+func ParseSCRAMVerifier(verifier string) (*UserCredentials, error) {
+	// SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>
+	parts := strings.Split(strings.TrimPrefix(verifier, scramVerifierPrefix), "$")
+	if !IsSCRAMVerifier(verifier) || len(parts) != 2 {
+		return nil, fmt.Errorf("invalid SCRAM verifier format")
+	}
+
+	iterSalt := strings.SplitN(parts[0], ":", 2)
+	keys := strings.SplitN(parts[1], ":", 2)
+	if len(iterSalt) != 2 || len(keys) != 2 {
+		return nil, fmt.Errorf("invalid SCRAM verifier format")
+	}
+
+	iterationCount, err := strconv.Atoi(iterSalt[0])
+	if err != nil || iterationCount <= 0 {
+		return nil, fmt.Errorf("invalid SCRAM verifier iteration count")
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(iterSalt[1])
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("invalid SCRAM verifier salt")
+	}
+
+	storedKey, err := base64.StdEncoding.DecodeString(keys[0])
+	if err != nil || len(storedKey) != sha256.Size {
+		return nil, fmt.Errorf("invalid SCRAM verifier StoredKey")
+	}
+
+	serverKey, err := base64.StdEncoding.DecodeString(keys[1])
+	if err != nil || len(serverKey) != sha256.Size {
+		return nil, fmt.Errorf("invalid SCRAM verifier ServerKey")
+	}
+
+	return &UserCredentials{
+		Salt:           salt,
+		StoredKey:      storedKey,
+		ServerKey:      serverKey,
+		IterationCount: iterationCount,
+	}, nil
+}
+
 // GetUser retrieves user credentials
 func (ac *AuthConfig) GetUser(username string) (*UserCredentials, bool) {
 	user, exists := ac.Users[username]
 	return user, exists
 }
 
-// NewSCRAMServer creates a new SCRAM authentication server
-func NewSCRAMServer(user *UserCredentials, tlsState *tls.ConnectionState) *SCRAMServer {
+// NewSCRAMServer creates a new SCRAM authentication server for the mechanism
+// chosen by the client. channelBinding is the tls-server-end-point data of the
+// connection, or nil if channel binding is not available.
+func NewSCRAMServer(user *UserCredentials, mechanism string, channelBinding []byte) *SCRAMServer {
 	return &SCRAMServer{
-		user:     user,
-		tlsState: tlsState,
+		user:           user,
+		mechanism:      mechanism,
+		channelBinding: channelBinding,
+	}
+}
+
+// SCRAMMechanisms returns the SASL mechanisms to advertise. SCRAM-SHA-256-PLUS
+// is only offered when channel binding data is available.
+func SCRAMMechanisms(channelBinding []byte) []string {
+	if channelBinding != nil {
+		return []string{SCRAMSHA256Plus, SCRAMSHA256}
 	}
+	return []string{SCRAMSHA256}
 }
 
 // HandleClientFirst processes the client-first-message
 func (s *SCRAMServer) HandleClientFirst(clientFirstMsg string) (string, error) {
-	s.clientFirstMsg = clientFirstMsg
-
-	// Parse client-first-message: n,,n=username,r=clientNonce
-	parts := strings.Split(clientFirstMsg, ",")
-	if len(parts) < 3 {
-		return "", fmt.Errorf("invalid client-first-message format")
+	first, err := parseSCRAMClientFirst(clientFirstMsg)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrSCRAMMalformed, err)
 	}
 
-	// Extract client nonce
-	for _, part := range parts {
-		if strings.HasPrefix(part, "r=") {
-			s.clientNonce = strings.TrimPrefix(part, "r=")
-			break
-		}
+	if err := s.checkGS2Header(first); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrSCRAMMalformed, err)
 	}
 
-	if s.clientNonce == "" {
-		return "", fmt.Errorf("client nonce not found")
+	// Like PostgreSQL, authenticate as the startup user and ignore the SCRAM
+	// username, but refuse to act on behalf of another identity
+	if first.authzid != "" {
+		return "", fmt.Errorf("%w: authorization identity is not supported", ErrSCRAMMalformed)
 	}
 
+	s.gs2Header = first.gs2Header
+	s.clientFirstBare = first.bare
+	s.clientNonce = first.nonce
+
 	// Generate server nonce
 	serverNonceBytes := make([]byte, 18)
 	if _, err := rand.Read(serverNonceBytes); err != nil {
@@ -133,54 +209,34 @@ func (s *SCRAMServer) HandleClientFirst(clientFirstMsg string) (string, error) {
 
 // HandleClientFinal processes the client-final-message and returns server-final-message
 func (s *SCRAMServer) HandleClientFinal(clientFinalMsg string) (string, error) {
-	// Parse client-final-message: c=channelBinding,r=nonce,p=clientProof
-	parts := strings.Split(clientFinalMsg, ",")
-	if len(parts) < 3 {
-		return "", fmt.Errorf("invalid client-final-message format")
-	}
-
-	var channelBinding, nonce, clientProofB64 string
-	for _, part := range parts {
-		if strings.HasPrefix(part, "c=") {
-			channelBinding = strings.TrimPrefix(part, "c=")
-		} else if strings.HasPrefix(part, "r=") {
-			nonce = strings.TrimPrefix(part, "r=")
-		} else if strings.HasPrefix(part, "p=") {
-			clientProofB64 = strings.TrimPrefix(part, "p=")
-		}
+	final, err := parseSCRAMClientFinal(clientFinalMsg)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrSCRAMMalformed, err)
 	}
 
 	// Verify nonce
 	expectedNonce := s.clientNonce + s.serverNonce
-	if nonce != expectedNonce {
+	if final.nonce != expectedNonce {
 		return "", fmt.Errorf("nonce mismatch")
 	}
 
 	// Verify channel binding
-	if err := s.verifyChannelBinding(channelBinding); err != nil {
+	if err := s.verifyChannelBinding(final.channelBinding); err != nil {
 		return "", fmt.Errorf("channel binding verification failed: %w", err)
 	}
 
 	// Build auth message
-	clientFirstBare := s.getClientFirstBare()
-	clientFinalWithoutProof := s.getClientFinalWithoutProof(clientFinalMsg)
-	s.authMessage = fmt.Sprintf("%s,%s,%s", clientFirstBare, s.serverFirstMsg, clientFinalWithoutProof)
-
-	// Verify client proof
-	clientProof, err := base64.StdEncoding.DecodeString(clientProofB64)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode client proof: %w", err)
-	}
+	s.authMessage = fmt.Sprintf("%s,%s,%s", s.clientFirstBare, s.serverFirstMsg, final.withoutProof)
 
 	// Calculate client signature
 	clientSignatureHMAC := hmac.New(sha256.New, s.user.StoredKey)
 	clientSignatureHMAC.Write([]byte(s.authMessage))
 	clientSignature := clientSignatureHMAC.Sum(nil)
 
-	// Recover client key
-	clientKey := make([]byte, len(clientProof))
+	// Recover client key; the parser guarantees the proof has the signature's length
+	clientKey := make([]byte, len(final.proof))
 	for i := range clientKey {
-		clientKey[i] = clientProof[i] ^ clientSignature[i]
+		clientKey[i] = final.proof[i] ^ clientSignature[i]
 	}
 
 	// Verify stored key
@@ -199,95 +255,91 @@ func (s *SCRAMServer) HandleClientFinal(clientFinalMsg string) (string, error) {
 	return serverFinal, nil
 }
 
-// verifyChannelBinding verifies the channel binding data
-func (s *SCRAMServer) verifyChannelBinding(channelBindingB64 string) error {
-	channelBindingData, err := base64.StdEncoding.DecodeString(channelBindingB64)
-	if err != nil {
-		return fmt.Errorf("failed to decode channel binding: %w", err)
-	}
-
-	// Channel binding format: gs2-header + channel-binding-data
-	// For tls-server-end-point: "p=tls-server-end-point,," + certificate-hash
-	
-	if len(channelBindingData) < 3 {
-		return fmt.Errorf("channel binding data too short")
-	}
-
-	// Check for tls-server-end-point binding
-	if s.tlsState != nil && len(s.tlsState.PeerCertificates) > 0 {
-		// Extract the channel binding type from the data
-		cbString := string(channelBindingData)
-		
-		if strings.HasPrefix(cbString, "p=tls-server-end-point,,") {
-			// Calculate expected certificate hash
-			cert := s.tlsState.PeerCertificates[0]
-			certHash := sha256.Sum256(cert.Raw)
-			
-			// The channel binding data should contain the certificate hash
-			expectedCB := append([]byte("p=tls-server-end-point,,"), certHash[:]...)
-			
-			if !hmac.Equal(channelBindingData, expectedCB) {
-				return fmt.Errorf("channel binding hash mismatch")
-			}
-		} else if strings.HasPrefix(cbString, "n,,") {
-			// Client doesn't support channel binding
-			return nil
+// checkGS2Header validates the channel binding flag of the client-first-message
+// against the negotiated mechanism, as required by RFC 5802 section 6
+func (s *SCRAMServer) checkGS2Header(first *scramClientFirst) error {
+	switch first.cbindFlag {
+	case "p":
+		if s.mechanism != SCRAMSHA256Plus {
+			return fmt.Errorf("channel binding requested but mechanism %s does not use it", s.mechanism)
 		}
-	} else {
-		// No TLS, expect "n,," (no channel binding)
-		if string(channelBindingData) != "n,," {
-			return fmt.Errorf("channel binding not supported without TLS")
+		if first.cbName != "tls-server-end-point" {
+			return fmt.Errorf("unsupported channel binding type: %s", first.cbName)
+		}
+	case "y":
+		if s.mechanism == SCRAMSHA256Plus {
+			return fmt.Errorf("channel binding flag 'y' is invalid with %s", SCRAMSHA256Plus)
+		}
+		// The client supports channel binding but thinks the server doesn't.
+		// Since we offered it, this indicates a downgrade attack.
+		if s.channelBinding != nil {
+			return fmt.Errorf("client supports channel binding but server offered it; possible downgrade attack")
+		}
+	case "n":
+		if s.mechanism == SCRAMSHA256Plus {
+			return fmt.Errorf("channel binding is required with %s", SCRAMSHA256Plus)
 		}
 	}
 
 	return nil
 }
 
-// getClientFirstBare extracts the bare part of client-first-message
-func (s *SCRAMServer) getClientFirstBare() string {
-	// Remove GS2 header (everything before first comma after "n")
-	parts := strings.SplitN(s.clientFirstMsg, ",", 3)
-	if len(parts) >= 3 {
-		return parts[2]
+// verifyChannelBinding verifies the c= attribute of the client-final-message.
+// It must contain the GS2 header, followed by the channel binding data for 'p'.
+func (s *SCRAMServer) verifyChannelBinding(channelBindingData []byte) error {
+	expected := []byte(s.gs2Header)
+	if strings.HasPrefix(s.gs2Header, "p=") {
+		expected = append(expected, s.channelBinding...)
 	}
-	return s.clientFirstMsg
-}
 
-// getClientFinalWithoutProof extracts client-final-message without proof
-func (s *SCRAMServer) getClientFinalWithoutProof(clientFinal string) string {
-	// Remove the proof part (p=...)
-	idx := strings.LastIndex(clientFinal, ",p=")
-	if idx != -1 {
-		return clientFinal[:idx]
+	if !hmac.Equal(channelBindingData, expected) {
+		return fmt.Errorf("channel binding mismatch")
 	}
-	return clientFinal
+
+	return nil
 }
 
 // ParseSCRAMClientFirst parses the initial SCRAM client message to extract username
 func ParseSCRAMClientFirst(data []byte) (string, error) {
-	msg := string(data)
-	parts := strings.Split(msg, ",")
-	
-	for _, part := range parts {
-		if strings.HasPrefix(part, "n=") {
-			username := strings.TrimPrefix(part, "n=")
-			// Unescape username (SASLprep would be applied here in production)
-			username = strings.ReplaceAll(username, "=3D", "=")
-			username = strings.ReplaceAll(username, "=2C", ",")
-			return username, nil
-		}
+	first, err := parseSCRAMClientFirst(string(data))
+	if err != nil {
+		return "", err
 	}
-	
-	return "", fmt.Errorf("username not found in SCRAM message")
+	return first.username, nil
 }
 
 // GetTLSServerEndPoint calculates the tls-server-end-point channel binding data
-func GetTLSServerEndPoint(tlsState *tls.ConnectionState) []byte {
-	if tlsState == nil || len(tlsState.PeerCertificates) == 0 {
-		return nil
+// (RFC 5929) for the proxy's own certificate. The certificate is hashed with
+// the hash function of its signature algorithm, with MD5 and SHA-1 upgraded to SHA-256.
+func GetTLSServerEndPoint(cert *x509.Certificate) ([]byte, error) {
+	if cert == nil {
+		return nil, fmt.Errorf("no server certificate")
+	}
+
+	var h hash.Hash
+	switch cert.SignatureAlgorithm {
+	case x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1,
+		x509.SHA256WithRSA, x509.SHA256WithRSAPSS, x509.DSAWithSHA256, x509.ECDSAWithSHA256:
+		h = sha256.New()
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		h = sha512.New384()
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512:
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("channel binding not defined for signature algorithm %s", cert.SignatureAlgorithm)
+	}
+
+	h.Write(cert.Raw)
+	return h.Sum(nil), nil
+}
+
+// leafCertificate returns the parsed leaf of a TLS certificate chain
+func leafCertificate(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("empty certificate chain")
+	}
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
 	}
-	
-	cert := tlsState.PeerCertificates[0]
-	certHash := sha256.Sum256(cert.Raw)
-	return certHash[:]
+	return x509.ParseCertificate(cert.Certificate[0])
 }
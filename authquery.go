@@ -0,0 +1,193 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AuthQueryConfig configures looking up SCRAM verifiers from the database,
+// like pgbouncer's auth_query
+type AuthQueryConfig struct {
+	Query          string        // Takes the username as $1, returns the verifier in its last column
+	DSN            string        // Backend to run the query on
+	Backend        string        // Name of that backend, empty for a separate AUTH_QUERY_DSN
+	CacheTTL       time.Duration // How long lookups, including misses, are cached
+	CacheSize      int           // Most cached results; the least recently used are evicted
+	MaxConnections int           // Most backend connections used for lookups at once
+}
+
+// authQueryEntry is a cached auth_query result
+type authQueryEntry struct {
+	username string
+	user     *UserCredentials // nil if the user doesn't exist or can't log in
+	expires  time.Time
+}
+
+// AuthQueryCache looks up users with the auth query and caches the results.
+// Unauthenticated clients choose the usernames, so both the cache and the
+// backend connections used for lookups are bounded.
+type AuthQueryCache struct {
+	router  *Router
+	config  *AuthQueryConfig
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Front is the most recently used entry
+
+	slots chan struct{}  // One per lookup in flight
+	idle  chan *pgx.Conn // Connections kept for the next lookup
+}
+
+// NewAuthQueryCache creates an auth query cache
+func NewAuthQueryCache(router *Router, config *AuthQueryConfig) *AuthQueryCache {
+	return &AuthQueryCache{
+		router:  router,
+		config:  config,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		slots:   make(chan struct{}, config.MaxConnections),
+		idle:    make(chan *pgx.Conn, config.MaxConnections),
+	}
+}
+
+// GetUser returns the credentials of a user, querying the database if the
+// cached result has expired. Lookup errors are not cached.
+func (c *AuthQueryCache) GetUser(ctx context.Context, username string) (*UserCredentials, bool, error) {
+	if entry, ok := c.cached(username); ok {
+		return entry.user, entry.user != nil, nil
+	}
+
+	user, err := c.lookup(ctx, username)
+	if err != nil {
+		return nil, false, err
+	}
+
+	c.store(&authQueryEntry{username: username, user: user, expires: time.Now().Add(c.config.CacheTTL)})
+
+	return user, user != nil, nil
+}
+
+// cached returns the unexpired cache entry of a user
+func (c *AuthQueryCache) cached(username string) (*authQueryEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.entries[username]
+	if !exists {
+		return nil, false
+	}
+	entry := elem.Value.(*authQueryEntry)
+	if !time.Now().Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, username)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry, true
+}
+
+// store caches a result, evicting the least recently used entries beyond CacheSize
+func (c *AuthQueryCache) store(entry *authQueryEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, exists := c.entries[entry.username]; exists {
+		c.lru.Remove(elem)
+	}
+	c.entries[entry.username] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.config.CacheSize {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*authQueryEntry).username)
+	}
+}
+
+// lookup runs the auth query for a user. Users without a row, with a NULL
+// password or with a non-SCRAM password can't log in and yield nil.
+func (c *AuthQueryCache) lookup(ctx context.Context, username string) (*UserCredentials, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// Wait for a free slot, so a flood of unknown usernames queues up here
+	// instead of opening a backend connection each
+	select {
+	case c.slots <- struct{}{}:
+		defer func() { <-c.slots }()
+	case <-ctx.Done():
+		return nil, fmt.Errorf("auth query backend busy: %w", ctx.Err())
+	}
+
+	result, err := c.query(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("auth query failed: %w", err)
+	}
+
+	if len(result.Rows) == 0 {
+		return nil, nil
+	}
+	row := result.Rows[0]
+	if len(row) == 0 || row[len(row)-1] == nil {
+		return nil, nil
+	}
+
+	secret := string(row[len(row)-1])
+	if !IsSCRAMVerifier(secret) {
+		log.Printf("Auth query returned a non-SCRAM password for user %s, ignoring it", username)
+		return nil, nil
+	}
+
+	user, err := ParseSCRAMVerifier(secret)
+	if err != nil {
+		return nil, fmt.Errorf("auth query returned an invalid verifier for user %s: %w", username, err)
+	}
+	user.Username = username
+
+	return user, nil
+}
+
+// query runs the auth query on an idle connection, or a new one if there is
+// none or the idle one turns out to be broken
+func (c *AuthQueryCache) query(ctx context.Context, username string) (*WriteResult, error) {
+	params := [][]byte{[]byte(username)}
+
+	select {
+	case conn := <-c.idle:
+		result, err := execOnConn(ctx, conn, c.config.Query, params, nil, nil)
+		if err == nil {
+			c.release(conn)
+			return result, nil
+		}
+		conn.Close(context.Background())
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	default:
+	}
+
+	conn, err := c.router.connectToBackend(ctx, c.config.Backend, c.config.DSN, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to auth query backend: %w", err)
+	}
+	result, err := execOnConn(ctx, conn, c.config.Query, params, nil, nil)
+	if err != nil {
+		conn.Close(context.Background())
+		return nil, err
+	}
+	c.release(conn)
+	return result, nil
+}
+
+// release keeps a connection for the next lookup
+func (c *AuthQueryCache) release(conn *pgx.Conn) {
+	select {
+	case c.idle <- conn:
+	default:
+		conn.Close(context.Background())
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AWSSecretsConfig configures the AWS Secrets Manager credential provider
+type AWSSecretsConfig struct {
+	SecretID     string // Secret name or ARN
+	Region       string
+	VersionStage string // Staging label to read, empty for AWSCURRENT
+	Endpoint     string // Secrets Manager endpoint, empty for the regional AWS endpoint
+	IMDSEndpoint string // Instance metadata endpoint for role credentials
+
+	HTTPClient *http.Client // Client for requests, nil for a default one
+}
+
+// AWSSecretsProvider loads credentials from AWS Secrets Manager. The
+// SecretString holds either the CredentialFile document or a JSON object
+// mapping usernames to passwords or user objects.
+type AWSSecretsProvider struct {
+	config      *AWSSecretsConfig
+	client      *http.Client
+	credentials *AWSCredentialChain
+
+	mu            sync.Mutex
+	lastVersionID string // VersionId of the last applied load
+	nextVersionID string // VersionId of the last load, recorded once it is applied
+}
+
+func NewAWSSecretsProvider(config *AWSSecretsConfig) *AWSSecretsProvider {
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	imdsEndpoint := config.IMDSEndpoint
+	if imdsEndpoint == "" {
+		imdsEndpoint = "http://169.254.169.254"
+	}
+
+	return &AWSSecretsProvider{
+		config: config,
+		client: client,
+		credentials: &AWSCredentialChain{
+			IMDSEndpoint: imdsEndpoint,
+			HTTPClient:   &http.Client{Timeout: 2 * time.Second},
+		},
+	}
+}
+
+func (p *AWSSecretsProvider) GetCredentials(ctx context.Context) (map[string]*UserEntry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// A failed load must not commit the version of an earlier one
+	p.nextVersionID = p.lastVersionID
+
+	secret, err := p.getSecretValue(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The version ID only changes when the secret value does
+	if p.lastVersionID != "" && secret.VersionID == p.lastVersionID {
+		return nil, ErrCredentialsNotModified
+	}
+
+	if secret.SecretString == "" {
+		return nil, fmt.Errorf("AWS secret %s has no SecretString", p.config.SecretID)
+	}
+	credentials, err := parseSecretDocument([]byte(secret.SecretString))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse AWS secret %s: %w", p.config.SecretID, err)
+	}
+
+	p.nextVersionID = secret.VersionID
+	return credentials, nil
+}
+
+// CommitCredentials records the VersionId of the last load once the manager
+// has validated and applied its credentials, so a rejected version is loaded
+// again instead of being skipped as unchanged
+func (p *AWSSecretsProvider) CommitCredentials() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastVersionID = p.nextVersionID
+}
+
+func (p *AWSSecretsProvider) SupportsReload() bool {
+	return true
+}
+
+// awsSecretValue is a GetSecretValue response
+type awsSecretValue struct {
+	Name         string `json:"Name"`
+	VersionID    string `json:"VersionId"`
+	SecretString string `json:"SecretString"`
+}
+
+// getSecretValue calls the Secrets Manager GetSecretValue API
+func (p *AWSSecretsProvider) getSecretValue(ctx context.Context) (*awsSecretValue, error) {
+	creds, err := p.credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	input := map[string]string{"SecretId": p.config.SecretID}
+	if p.config.VersionStage != "" {
+		input["VersionStage"] = p.config.VersionStage
+	}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := p.config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", p.config.Region)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWSRequest(req, body, creds, p.config.Region, "secretsmanager", time.Now())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("GetSecretValue request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var awsErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &awsErr)
+		return nil, fmt.Errorf("GetSecretValue returned status %d: %s %s", resp.StatusCode, awsErr.Type, awsErr.Message)
+	}
+
+	var secret awsSecretValue
+	if err := json.Unmarshal(data, &secret); err != nil {
+		return nil, fmt.Errorf("failed to decode GetSecretValue response: %w", err)
+	}
+	return &secret, nil
+}
+
+// loadAWSSecretsConfig loads AWS Secrets Manager settings from environment
+func loadAWSSecretsConfig() (*AWSSecretsConfig, error) {
+	config := &AWSSecretsConfig{
+		SecretID:     os.Getenv("AWS_SECRET_NAME"),
+		Region:       os.Getenv("AWS_REGION"),
+		VersionStage: os.Getenv("AWS_SECRET_VERSION_STAGE"),
+		Endpoint:     os.Getenv("AWS_SECRETS_MANAGER_ENDPOINT"),
+		IMDSEndpoint: os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"),
+	}
+	if config.SecretID == "" || config.Region == "" {
+		return nil, fmt.Errorf("AWS_SECRET_NAME and AWS_REGION must be set")
+	}
+	return config, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// dsnTLSParams are the connection parameters through which a DSN configures TLS itself
+var dsnTLSParams = []string{"sslmode", "sslrootcert", "sslcert", "sslkey"}
+
+// dsnConfiguresTLS reports whether a DSN sets any TLS parameter, in URL or
+// keyword/value form
+func dsnConfiguresTLS(dsn string) bool {
+	for _, param := range dsnTLSParams {
+		if _, ok := dsnParam(dsn, param); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// dsnParam returns a connection parameter of a DSN in URL or keyword/value form
+func dsnParam(dsn, param string) (string, bool) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", false
+		}
+		query := u.Query()
+		return query.Get(param), query.Has(param)
+	}
+
+	for _, field := range strings.Fields(dsn) {
+		if value, ok := strings.CutPrefix(field, param+"="); ok {
+			return strings.Trim(value, "'"), true
+		}
+	}
+	return "", false
+}
+
+// sslModeStrength orders sslmode values from weakest to strongest
+var sslModeStrength = map[string]int{"disable": 0, "allow": 1, "prefer": 2, "require": 3, "verify-ca": 4, "verify-full": 5}
+
+// checkDSN rejects a DSN whose TLS parameters, which take precedence over
+// BACKEND_TLS_MODE, ask for weaker TLS than it. A per-backend mode overrides
+// the DSN, so it is the way to weaken TLS for one backend on purpose.
+func (c *BackendTLSConfig) checkDSN(label, name, dsn string) error {
+	if _, ok := c.Backends[name]; ok || !c.Enabled || !dsnConfiguresTLS(dsn) {
+		return nil
+	}
+
+	mode, ok := dsnParam(dsn, "sslmode")
+	if !ok {
+		mode = "prefer"
+	}
+	if sslModeStrength[mode] >= sslModeStrength[c.Mode] {
+		return nil
+	}
+
+	if name == "" {
+		return fmt.Errorf("%s sets sslmode=%s, weaker than BACKEND_TLS_MODE=%s", label, mode, c.Mode)
+	}
+	return fmt.Errorf("%s DSN sets sslmode=%s, weaker than BACKEND_TLS_MODE=%s; set BACKEND_TLS_%s_MODE to allow it",
+		label, mode, c.Mode, strings.ToUpper(name))
+}
+
+// applyBackendTLS sets the TLS configuration of a backend connection. Per-backend
+// settings come first, then TLS parameters in the DSN, which pgx has already
+// applied, then the global default.
+func (c *BackendTLSConfig) applyBackendTLS(config *pgx.ConnConfig, name, dsn string) {
+	settings, ok := c.Backends[name]
+	if !ok {
+		if dsnConfiguresTLS(dsn) || !c.Enabled {
+			return
+		}
+		settings = c
+	}
+
+	config.TLSConfig = nil
+	if settings.Enabled {
+		config.TLSConfig = settings.clientConfig(config.Host)
+	}
+
+	// Keep the other hosts of multi-host DSNs, but not the plaintext or TLS
+	// retries that sslmode=prefer or allow adds for each host
+	type hostPort struct {
+		host string
+		port uint16
+	}
+	seen := map[hostPort]bool{{config.Host, config.Port}: true}
+	var fallbacks []*pgconn.FallbackConfig
+	for _, fallback := range config.Fallbacks {
+		if seen[hostPort{fallback.Host, fallback.Port}] {
+			continue
+		}
+		seen[hostPort{fallback.Host, fallback.Port}] = true
+		var tlsConfig *tls.Config
+		if settings.Enabled {
+			tlsConfig = settings.clientConfig(fallback.Host)
+		}
+		fallbacks = append(fallbacks, &pgconn.FallbackConfig{
+			Host:      fallback.Host,
+			Port:      fallback.Port,
+			TLSConfig: tlsConfig,
+		})
+	}
+	config.Fallbacks = fallbacks
+}
+
+// clientConfig returns the tls.Config for a connection to host, which is the
+// SNI and verify-full name unless ServerName is set
+func (c *BackendTLSConfig) clientConfig(host string) *tls.Config {
+	tlsConf := c.TLS.Clone()
+	if c.ServerName != "" {
+		tlsConf.ServerName = c.ServerName
+	} else {
+		tlsConf.ServerName = host
+	}
+	return tlsConf
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestCheckDSN(t *testing.T) {
+	config := &BackendTLSConfig{
+		Enabled: true,
+		Mode:    "verify-full",
+		Backends: map[string]*BackendTLSConfig{
+			"writer1": {Enabled: false, Mode: "disable"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		dsn     string
+		wantErr bool
+	}{
+		{"reader", "postgres://u@db:5432/app", false},
+		{"reader", "postgres://u@db:5432/app?sslmode=verify-full", false},
+		{"reader", "postgres://u@db:5432/app?sslmode=disable", true},
+		{"reader", "postgres://u@db:5432/app?sslmode=require", true},
+		{"reader", "postgres://u@db:5432/app?sslrootcert=/ca.pem", true}, // sslmode defaults to prefer
+		{"writer0", "host=db dbname=app sslmode=prefer", true},
+		{"writer0", "host=db dbname=app sslmode='verify-full'", false},
+		{"writer1", "postgres://u@db:5432/app?sslmode=disable", false}, // per-backend mode allows it
+	}
+
+	for _, tt := range tests {
+		err := config.checkDSN(tt.name, tt.name, tt.dsn)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkDSN(%s, %q) error = %v, wantErr %v", tt.name, tt.dsn, err, tt.wantErr)
+		}
+	}
+
+	disabled := &BackendTLSConfig{Mode: "disable"}
+	if err := disabled.checkDSN("reader", "reader", "postgres://u@db/app?sslmode=disable"); err != nil {
+		t.Errorf("checkDSN() with backend TLS disabled error = %v", err)
+	}
+}
+
+func TestApplyBackendTLSKeepsFallbackHosts(t *testing.T) {
+	const dsn = "postgres://u@db1:5432,db2:5433/app?sslmode=prefer"
+	config := &BackendTLSConfig{
+		Enabled: true,
+		Mode:    "require",
+		TLS:     &tls.Config{InsecureSkipVerify: true},
+		Backends: map[string]*BackendTLSConfig{
+			"reader": {Enabled: false, Mode: "disable"},
+		},
+	}
+
+	for _, name := range []string{"reader", "writer0"} {
+		t.Run(name, func(t *testing.T) {
+			connConfig, err := pgx.ParseConfig(dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The DSN configures TLS itself, so only an override replaces it
+			config.Backends["writer0"] = &BackendTLSConfig{Enabled: true, Mode: "require", TLS: config.TLS}
+			config.applyBackendTLS(connConfig, name, dsn)
+
+			enabled := name == "writer0"
+			if (connConfig.TLSConfig != nil) != enabled {
+				t.Errorf("TLSConfig set = %v, want %v", connConfig.TLSConfig != nil, enabled)
+			}
+			if len(connConfig.Fallbacks) != 1 || connConfig.Fallbacks[0].Host != "db2" || connConfig.Fallbacks[0].Port != 5433 {
+				t.Fatalf("Fallbacks = %+v, want only db2:5433", connConfig.Fallbacks)
+			}
+			fallbackTLS := connConfig.Fallbacks[0].TLSConfig
+			if (fallbackTLS != nil) != enabled {
+				t.Errorf("fallback TLSConfig set = %v, want %v", fallbackTLS != nil, enabled)
+			}
+			if enabled && fallbackTLS.ServerName != "db2" {
+				t.Errorf("fallback ServerName = %q, want db2", fallbackTLS.ServerName)
+			}
+		})
+	}
+}
+
+func TestCheckBackendTLSNames(t *testing.T) {
+	dsns := map[string]string{"reader": "r", "writer0": "w0", "writer1": "w1"}
+
+	tests := []struct {
+		env     string
+		wantErr bool
+	}{
+		{"BACKEND_TLS_MODE", false},
+		{"BACKEND_TLS_READER_MODE", false},
+		{"BACKEND_TLS_WRITER1_CLIENT_KEY", false},
+		{"BACKEND_TLS_WRITER2_MODE", true},
+		{"BACKEND_TLS_NOTIFY_MODE", true},
+		{"BACKEND_TLS_REPLICA_ROOT_CA", true},
+		{"BACKEND_TLS_READER_ROOTCA", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Setenv(tt.env, "x")
+			err := checkBackendTLSNames(dsns)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkBackendTLSNames() with %s error = %v, wantErr %v", tt.env, err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Setenv("BACKEND_TLS_NOTIFY_MODE", "require")
+	dsns["notify"] = "n"
+	if err := checkBackendTLSNames(dsns); err != nil {
+		t.Errorf("checkBackendTLSNames() with PG_NOTIFY_DSN set error = %v", err)
+	}
+}
+
+func TestRequireModeDropsInheritedRevocationChecks(t *testing.T) {
+	defaults := &BackendTLSConfig{Enabled: true, Mode: "verify-full", CRLFile: "/etc/pprox/ca.crl", OCSP: OCSPCheck}
+	t.Setenv("BACKEND_TLS_WRITER0_MODE", "require")
+
+	settings, err := loadBackendTLSSettings("BACKEND_TLS_WRITER0_", defaults)
+	if err != nil {
+		t.Fatalf("loadBackendTLSSettings() error = %v", err)
+	}
+	if settings.CRLFile != "" || settings.OCSP != OCSPOff {
+		t.Errorf("require mode kept CRL %q and OCSP %q, want none", settings.CRLFile, settings.OCSP)
+	}
+
+	t.Setenv("BACKEND_TLS_WRITER0_OCSP", OCSPRequire)
+	if _, err := loadBackendTLSSettings("BACKEND_TLS_WRITER0_", defaults); err == nil {
+		t.Error("loadBackendTLSSettings() with OCSP set for require mode succeeded")
+	}
+}
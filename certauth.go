@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// Client certificate authentication modes
+const (
+	ClientCertNone     = "none"     // Client certificates are not requested
+	ClientCertOptional = "optional" // Certificates are verified if presented
+	ClientCertRequire  = "require"  // Every connection must present a valid certificate
+)
+
+// clientCertificate returns the verified client certificate of the connection, if any
+func (h *ClientHandler) clientCertificate() *x509.Certificate {
+	if h.tlsState == nil || len(h.tlsState.VerifiedChains) == 0 || len(h.tlsState.PeerCertificates) == 0 {
+		return nil
+	}
+	return h.tlsState.PeerCertificates[0]
+}
+
+// certificateIdentities returns the names a client certificate may be mapped
+// from: the subject CN followed by the DNS, email and URI SANs
+func certificateIdentities(cert *x509.Certificate) []string {
+	var identities []string
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	return identities
+}
+
+// certificateMatchesUser checks whether a certificate identity maps to the
+// PostgreSQL user. Without an ident map, the CN must equal the username.
+func certificateMatchesUser(cert *x509.Certificate, identMap *IdentMap, mapName, username string) bool {
+	if identMap == nil {
+		return cert.Subject.CommonName == username
+	}
+
+	for _, identity := range certificateIdentities(cert) {
+		if identMap.Matches(mapName, identity, username) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateClientCert checks the client certificate against the startup user.
+// It returns true if the certificate authenticates the user on its own, so
+// the SCRAM exchange can be skipped.
+func (h *ClientHandler) authenticateClientCert(username string) (bool, error) {
+	tlsConfig := h.router.config.TLSConfig
+
+	cert := h.clientCertificate()
+	if cert == nil {
+		if tlsConfig.ClientAuth == ClientCertRequire {
+			return false, fmt.Errorf("connection requires a valid client certificate")
+		}
+		return false, nil
+	}
+
+	if !certificateMatchesUser(cert, tlsConfig.IdentMap, tlsConfig.IdentMapName, username) {
+		return false, fmt.Errorf("certificate authentication failed for user \"%s\"", username)
+	}
+
+	return tlsConfig.certSkipsSCRAM(username), nil
+}
+
+// certSkipsSCRAM reports whether a valid certificate replaces SCRAM for the user
+func (c *TLSConfig) certSkipsSCRAM(username string) bool {
+	for _, user := range c.CertSkipSCRAMUsers {
+		if user == "*" || user == username {
+			return true
+		}
+	}
+	return false
+}
+
+// parseUserList parses a comma-separated list of usernames
+func parseUserList(value string) []string {
+	var users []string
+	for _, user := range strings.Split(value, ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			users = append(users, user)
+		}
+	}
+	return users
+}
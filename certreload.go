@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the key pair is re-read regardless of file
+// events, which also repeats the expiry warning
+const certCheckInterval = time.Hour
+
+// certExpiryWarning is how close to expiry the served certificate is logged as a warning
+const certExpiryWarning = 14 * 24 * time.Hour
+
+// Served certificate state for alerting, on /debug/vars when METRICS_ADDR is set
+var (
+	tlsCertificateNotAfter = expvar.NewInt("tls_certificate_not_after") // Unix seconds
+	tlsCertificateReloads  = expvar.NewMap("tls_certificate_reloads")
+)
+
+// CertificateReloader serves the client-facing key pair through
+// tls.Config.GetCertificate and swaps in a new pair when the files change, so
+// renewals by cert-manager or an ACME client don't need a restart
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate // Leaf is always set
+}
+
+// NewCertificateReloader loads the key pair; call Watch to pick up changes
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{certFile: certFile, keyFile: keyFile}
+
+	cert, err := loadKeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	r.setCertificate(cert)
+
+	return r, nil
+}
+
+// loadKeyPair loads a certificate and key and checks that they belong together
+func loadKeyPair(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("failed to parse TLS certificate: %w", err)
+		}
+	}
+	return &cert, nil
+}
+
+// Reload re-reads the key pair. A pair that fails to load or has already
+// expired is rejected and the current certificate stays in use.
+func (r *CertificateReloader) Reload() (bool, error) {
+	cert, err := loadKeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	if time.Now().After(cert.Leaf.NotAfter) {
+		return false, fmt.Errorf("certificate %s expired on %s", cert.Leaf.Subject, cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	current := r.Current()
+	if current != nil && current.Leaf.Equal(cert.Leaf) {
+		return false, nil
+	}
+
+	r.setCertificate(cert)
+	return true, nil
+}
+
+func (r *CertificateReloader) setCertificate(cert *tls.Certificate) {
+	r.mu.Lock()
+	r.cert = cert
+	r.mu.Unlock()
+
+	tlsCertificateNotAfter.Set(cert.Leaf.NotAfter.Unix())
+	log.Printf("Serving TLS certificate %s, valid until %s", cert.Leaf.Subject, cert.Leaf.NotAfter.Format(time.RFC3339))
+	r.checkExpiry()
+}
+
+// checkExpiry logs a warning if the served certificate expires soon
+func (r *CertificateReloader) checkExpiry() {
+	cert := r.Current()
+	remaining := time.Until(cert.Leaf.NotAfter)
+	switch {
+	case remaining <= 0:
+		log.Printf("WARNING: TLS certificate %s expired on %s", cert.Leaf.Subject, cert.Leaf.NotAfter.Format(time.RFC3339))
+	case remaining < certExpiryWarning:
+		log.Printf("WARNING: TLS certificate %s expires in %s", cert.Leaf.Subject, remaining.Round(time.Hour))
+	}
+}
+
+// Current returns the certificate being served
+func (r *CertificateReloader) Current() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Current(), nil
+}
+
+// Watch reloads the key pair when its files change and every certCheckInterval
+func (r *CertificateReloader) Watch() {
+	var events <-chan struct{}
+	watcher, err := watchFiles([]string{r.certFile, r.keyFile})
+	if err != nil {
+		log.Printf("Failed to watch TLS certificate files, checking every %s: %v", certCheckInterval, err)
+	} else {
+		events = watcher.Events()
+	}
+
+	go func() {
+		ticker := time.NewTicker(certCheckInterval)
+		defer ticker.Stop()
+
+		// Debounce, as the certificate and key are usually written one after the other
+		var settled <-chan time.Time
+		for {
+			select {
+			case <-events:
+				settled = time.After(credentialWatchDebounce)
+			case <-settled:
+				settled = nil
+				r.reload()
+			case <-ticker.C:
+				if !r.reload() {
+					r.checkExpiry()
+				}
+			}
+		}
+	}()
+}
+
+// reload reloads the key pair, records the outcome and reports whether the
+// certificate changed
+func (r *CertificateReloader) reload() bool {
+	changed, err := r.Reload()
+	switch {
+	case err != nil:
+		tlsCertificateReloads.Add("failure", 1)
+		log.Printf("Failed to reload TLS certificate, keeping the current one: %v", err)
+	case changed:
+		tlsCertificateReloads.Add("success", 1)
+	}
+	return changed
+}
+
+// connectionConfig returns the tls.Config for one client connection and a
+// function reporting the certificate it served, for channel binding
+func (c *TLSConfig) connectionConfig() (*tls.Config, func() *tls.Certificate) {
+	var served *tls.Certificate
+	config := c.TLS.Clone()
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := c.TLS.GetCertificate(hello)
+		served = cert
+		return cert, err
+	}
+	return config, func() *tls.Certificate { return served }
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// OCSP stapling policies for backend certificates
+const (
+	OCSPOff     = "off"     // Ignore stapled responses
+	OCSPCheck   = "check"   // Reject revoked certificates if a response is stapled
+	OCSPRequire = "require" // Also reject servers that don't staple a good response
+)
+
+// ocspClockSkew is the tolerance for OCSP response validity times
+const ocspClockSkew = 5 * time.Minute
+
+// backendVerifier checks backend certificates beyond what crypto/tls does:
+// the chain in verify-ca mode, where crypto/tls has to skip verification
+// entirely to skip the host name check, and revocation through CRLs and
+// stapled OCSP responses
+type backendVerifier struct {
+	roots       *x509.CertPool
+	verifyChain bool    // verify-ca: InsecureSkipVerify is set, so verify here
+	crls        *crlSet // nil without BACKEND_TLS_CRL
+	ocsp        string
+}
+
+// verifyConnection implements tls.Config.VerifyConnection
+func (v *backendVerifier) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("backend presented no certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+
+	chains := cs.VerifiedChains
+	if v.verifyChain {
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		var err error
+		chains, err = leaf.Verify(x509.VerifyOptions{
+			Roots:         v.roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		if err != nil {
+			return fmt.Errorf("backend certificate verification failed: %w", err)
+		}
+	}
+	if len(chains) == 0 {
+		return errors.New("backend certificate chain was not verified")
+	}
+	chain := chains[0]
+
+	if v.crls != nil {
+		if err := v.crls.check(chain); err != nil {
+			return err
+		}
+	}
+	if v.ocsp != "" && v.ocsp != OCSPOff {
+		if err := v.checkOCSP(cs.OCSPResponse, chain); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkOCSP checks the OCSP response stapled by the backend for the leaf
+func (v *backendVerifier) checkOCSP(staple []byte, chain []*x509.Certificate) error {
+	if len(staple) == 0 {
+		if v.ocsp == OCSPRequire {
+			return errors.New("backend did not staple an OCSP response")
+		}
+		return nil
+	}
+	if len(chain) < 2 {
+		return errors.New("cannot check the OCSP response of a self-signed backend certificate")
+	}
+
+	leaf, issuer := chain[0], chain[1]
+	resp, err := ocsp.ParseResponseForCert(staple, leaf, issuer)
+	if err != nil {
+		return fmt.Errorf("invalid stapled OCSP response: %w", err)
+	}
+
+	now := time.Now()
+	if resp.ThisUpdate.After(now.Add(ocspClockSkew)) {
+		return errors.New("stapled OCSP response is not yet valid")
+	}
+	if !resp.NextUpdate.IsZero() && resp.NextUpdate.Before(now.Add(-ocspClockSkew)) {
+		return fmt.Errorf("stapled OCSP response expired on %s", resp.NextUpdate.Format(time.RFC3339))
+	}
+
+	switch resp.Status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		return fmt.Errorf("backend certificate %s was revoked on %s", leaf.Subject, resp.RevokedAt.Format(time.RFC3339))
+	default:
+		if v.ocsp == OCSPRequire {
+			return fmt.Errorf("OCSP status of backend certificate %s is unknown", leaf.Subject)
+		}
+		return nil
+	}
+}
+
+// crlSet holds the revocation lists of a comma-separated list of files,
+// re-read when a file changes so refreshed CRLs apply without a restart
+type crlSet struct {
+	paths []string
+
+	mu       sync.Mutex
+	modTimes []time.Time
+	lists    []*x509.RevocationList
+}
+
+// newCRLSet loads the CRL files, which may hold PEM or DER lists
+func newCRLSet(paths string) (*crlSet, error) {
+	s := &crlSet{}
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			s.paths = append(s.paths, path)
+		}
+	}
+	if _, err := s.current(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// current returns the revocation lists, reloading them if a file changed
+func (s *crlSet) current() ([]*x509.RevocationList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	modTimes := make([]time.Time, len(s.paths))
+	changed := len(s.modTimes) != len(s.paths)
+	for i, path := range s.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CRL: %w", err)
+		}
+		modTimes[i] = info.ModTime()
+		if !changed && !modTimes[i].Equal(s.modTimes[i]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return s.lists, nil
+	}
+
+	var lists []*x509.RevocationList
+	for _, path := range s.paths {
+		parsed, err := parseCRLFile(path)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, parsed...)
+	}
+	s.lists = lists
+	s.modTimes = modTimes
+	return lists, nil
+}
+
+// parseCRLFile parses the PEM "X509 CRL" blocks of a file, or the file as one DER list
+func parseCRLFile(path string) ([]*x509.RevocationList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL: %w", err)
+	}
+
+	var lists []*x509.RevocationList
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		list, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CRL in %s: %w", path, err)
+		}
+		lists = append(lists, list)
+	}
+	if lists != nil {
+		return lists, nil
+	}
+
+	list, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CRL in %s: %w", path, err)
+	}
+	return []*x509.RevocationList{list}, nil
+}
+
+// check rejects chains with a revoked certificate. A current CRL signed by
+// the leaf's issuer is required; intermediates are checked when a CRL of
+// their issuer is loaded.
+func (s *crlSet) check(chain []*x509.Certificate) error {
+	lists, err := s.current()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+
+		found := false
+		for _, list := range lists {
+			if !bytes.Equal(list.RawIssuer, issuer.RawSubject) || list.CheckSignatureFrom(issuer) != nil {
+				continue
+			}
+			if !list.NextUpdate.IsZero() && list.NextUpdate.Before(now) {
+				return fmt.Errorf("CRL of %s expired on %s", issuer.Subject, list.NextUpdate.Format(time.RFC3339))
+			}
+			found = true
+			for _, entry := range list.RevokedCertificateEntries {
+				if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return fmt.Errorf("backend certificate %s (serial %s) is revoked", cert.Subject, cert.SerialNumber)
+				}
+			}
+		}
+
+		if !found && i == 0 {
+			return fmt.Errorf("no CRL of %s, the issuer of the backend certificate", issuer.Subject)
+		}
+	}
+
+	return nil
+}
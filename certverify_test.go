@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testCert is a certificate and its key
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert creates a certificate signed by parent, or self-signed if parent is nil
+func newTestCert(t *testing.T, name string, serial int64, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else {
+		template.DNSNames = []string{name}
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// testPKI is a root CA, an intermediate CA and a server certificate for db.example
+type testPKI struct {
+	root, intermediate, leaf *testCert
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	root := newTestCert(t, "Test Root CA", 1, nil, true)
+	intermediate := newTestCert(t, "Test Intermediate CA", 2, root, true)
+	leaf := newTestCert(t, "db.example", 3, intermediate, false)
+	return &testPKI{root: root, intermediate: intermediate, leaf: leaf}
+}
+
+// writePEM writes a certificate or CRL to a temporary file and returns its path
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestCRL writes a CRL of issuer revoking the given serials
+func newTestCRL(t *testing.T, issuer *testCert, nextUpdate time.Time, revoked ...int64) string {
+	t.Helper()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-2 * time.Hour),
+		NextUpdate: nextUpdate,
+	}
+	for _, serial := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now().Add(-time.Hour),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, issuer.cert, issuer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "X509 CRL", der)
+}
+
+// handshake connects a client using config to a server presenting the PKI's chain
+func (p *testPKI) handshake(t *testing.T, config *tls.Config) error {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	server := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{p.leaf.cert.Raw, p.intermediate.cert.Raw},
+			PrivateKey:  p.leaf.key,
+		}},
+	})
+	go server.Handshake()
+
+	return tls.Client(clientConn, config).Handshake()
+}
+
+func TestBackendTLSVerifyModes(t *testing.T) {
+	pki := newTestPKI(t)
+	other := newTestCert(t, "Other CA", 10, nil, true)
+	rootFile := writePEM(t, "CERTIFICATE", pki.root.cert.Raw)
+	otherFile := writePEM(t, "CERTIFICATE", other.cert.Raw)
+
+	tests := []struct {
+		name       string
+		mode       string
+		rootCA     string
+		serverName string
+		wantErr    bool
+	}{
+		{"verify-ca trusted CA", "verify-ca", rootFile, "db.example", false},
+		{"verify-ca ignores host name", "verify-ca", rootFile, "other.example", false},
+		{"verify-ca other CA", "verify-ca", otherFile, "db.example", true},
+		{"verify-full trusted CA", "verify-full", rootFile, "db.example", false},
+		{"verify-full wrong host name", "verify-full", rootFile, "other.example", true},
+		{"verify-full other CA", "verify-full", otherFile, "db.example", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &BackendTLSConfig{Enabled: true, Mode: tt.mode, RootCAFile: tt.rootCA, OCSP: OCSPOff}
+			tlsConfig, err := buildBackendTLSConfig(config)
+			if err != nil {
+				t.Fatalf("buildBackendTLSConfig() error = %v", err)
+			}
+			config.TLS = tlsConfig
+
+			err = pki.handshake(t, config.clientConfig(tt.serverName))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("handshake error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBackendVerifierCRL(t *testing.T) {
+	pki := newTestPKI(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.root.cert)
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{pki.leaf.cert, pki.intermediate.cert}}
+	later := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		crls    func() []string
+		wantErr bool
+	}{
+		{"not revoked", func() []string { return []string{newTestCRL(t, pki.intermediate, later, 99)} }, false},
+		{"leaf revoked", func() []string { return []string{newTestCRL(t, pki.intermediate, later, 3)} }, true},
+		{"intermediate revoked", func() []string {
+			return []string{newTestCRL(t, pki.intermediate, later), newTestCRL(t, pki.root, later, 2)}
+		}, true},
+		{"expired CRL", func() []string { return []string{newTestCRL(t, pki.intermediate, time.Now().Add(-time.Minute))} }, true},
+		{"no CRL of the issuer", func() []string { return []string{newTestCRL(t, pki.root, later)} }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := tt.crls()
+			joined := paths[0]
+			for _, path := range paths[1:] {
+				joined += "," + path
+			}
+			crls, err := newCRLSet(joined)
+			if err != nil {
+				t.Fatalf("newCRLSet() error = %v", err)
+			}
+
+			v := &backendVerifier{roots: roots, verifyChain: true, crls: crls, ocsp: OCSPOff}
+			err = v.verifyConnection(state)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyConnection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBackendVerifierOCSP(t *testing.T) {
+	pki := newTestPKI(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.root.cert)
+
+	staple := func(status int) []byte {
+		template := ocsp.Response{
+			Status:       status,
+			SerialNumber: pki.leaf.cert.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}
+		if status == ocsp.Revoked {
+			template.RevokedAt = time.Now().Add(-time.Minute)
+		}
+		resp, err := ocsp.CreateResponse(pki.intermediate.cert, pki.intermediate.cert, template, crypto.Signer(pki.intermediate.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	good, revoked := staple(ocsp.Good), staple(ocsp.Revoked)
+
+	tests := []struct {
+		name    string
+		policy  string
+		staple  []byte
+		wantErr bool
+	}{
+		{"check good", OCSPCheck, good, false},
+		{"check revoked", OCSPCheck, revoked, true},
+		{"check missing", OCSPCheck, nil, false},
+		{"check malformed", OCSPCheck, []byte("not ocsp"), true},
+		{"require good", OCSPRequire, good, false},
+		{"require revoked", OCSPRequire, revoked, true},
+		{"require missing", OCSPRequire, nil, true},
+		{"off revoked", OCSPOff, revoked, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &backendVerifier{roots: roots, verifyChain: true, ocsp: tt.policy}
+			state := tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{pki.leaf.cert, pki.intermediate.cert},
+				OCSPResponse:     tt.staple,
+			}
+			err := v.verifyConnection(state)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyConnection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,42 +1,60 @@
 package main
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
 	"flag"
 	"fmt"
-	"io"
 	"os"
+
+	"github.com/sausheong/pprox/internal/credfile"
 )
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "verifier" {
+		runVerifier(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "user" {
+		runUser(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && (os.Args[1] == "rotate" || os.Args[1] == "migrate") {
+		runRotate(os.Args[1], os.Args[2:])
+		return
+	}
+
 	inputFile := flag.String("input", "", "Input credential file (JSON)")
 	outputFile := flag.String("output", "", "Output encrypted file")
-	encryptionKey := flag.String("key", "", "Encryption key (32 bytes)")
+	encryptionKey := flag.String("key", "", "Encryption passphrase")
+	kdf := flag.String("kdf", "argon2id", "Key derivation function: argon2id or scrypt")
 	decrypt := flag.Bool("decrypt", false, "Decrypt instead of encrypt")
 
 	flag.Parse()
 
 	if *inputFile == "" || *outputFile == "" || *encryptionKey == "" {
 		fmt.Println("Usage:")
-		fmt.Println("  Encrypt: ./encrypt-credentials -input creds.json -output creds.enc -key 'your-32-byte-key'")
-		fmt.Println("  Decrypt: ./encrypt-credentials -input creds.enc -output creds.json -key 'your-32-byte-key' -decrypt")
+		fmt.Println("  Encrypt: ./encrypt-credentials -input creds.json -output creds.enc -key 'passphrase' [-kdf argon2id|scrypt]")
+		fmt.Println("  Decrypt: ./encrypt-credentials -input creds.enc -output creds.json -key 'passphrase' -decrypt")
+		fmt.Println("  Re-encrypt under a new passphrase: ./encrypt-credentials rotate -input creds.enc -key 'old' -new-key 'new'")
+		fmt.Println("  Convert a legacy file in place: ./encrypt-credentials migrate -input creds.enc -key 'passphrase'")
+		fmt.Println("  Manage users without decrypting to disk: ./encrypt-credentials user add|remove|list|passwd -file creds.enc ...")
+		fmt.Println("  SCRAM verifier from password prompt: ./encrypt-credentials verifier [-user alice]")
 		os.Exit(1)
 	}
 
-	// Derive 32-byte key
-	key := make([]byte, 32)
-	copy(key, []byte(*encryptionKey))
-
 	if *decrypt {
-		if err := decryptFile(*inputFile, *outputFile, key); err != nil {
+		if err := decryptFile(*inputFile, *outputFile, *encryptionKey); err != nil {
 			fmt.Printf("Decryption failed: %v\n", err)
 			os.Exit(1)
 		}
 		fmt.Printf("Successfully decrypted %s to %s\n", *inputFile, *outputFile)
 	} else {
-		if err := encryptFile(*inputFile, *outputFile, key); err != nil {
+		params, err := credfile.ParseKDF(*kdf)
+		if err != nil {
+			fmt.Printf("Encryption failed: %v\n", err)
+			os.Exit(1)
+		}
+		if err := encryptFile(*inputFile, *outputFile, *encryptionKey, params); err != nil {
 			fmt.Printf("Encryption failed: %v\n", err)
 			os.Exit(1)
 		}
@@ -46,33 +64,18 @@ func main() {
 	}
 }
 
-func encryptFile(inputPath, outputPath string, key []byte) error {
+func encryptFile(inputPath, outputPath, passphrase string, params credfile.Params) error {
 	// Read input file
 	data, err := os.ReadFile(inputPath)
 	if err != nil {
 		return fmt.Errorf("failed to read input file: %w", err)
 	}
 
-	// Create cipher
-	block, err := aes.NewCipher(key)
+	ciphertext, err := credfile.Encrypt(data, passphrase, params)
 	if err != nil {
 		return err
 	}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return err
-	}
-
-	// Generate nonce
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return err
-	}
-
-	// Encrypt
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
-
 	// Write output file
 	if err := os.WriteFile(outputPath, ciphertext, 0600); err != nil {
 		return fmt.Errorf("failed to write encrypted file: %w", err)
@@ -81,34 +84,14 @@ func encryptFile(inputPath, outputPath string, key []byte) error {
 	return nil
 }
 
-func decryptFile(inputPath, outputPath string, key []byte) error {
-	// Read encrypted file
+func decryptFile(inputPath, outputPath, passphrase string) error {
+	// Read encrypted file, legacy or envelope format
 	ciphertext, err := os.ReadFile(inputPath)
 	if err != nil {
 		return fmt.Errorf("failed to read encrypted file: %w", err)
 	}
 
-	// Create cipher
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return err
-	}
-
-	// Extract nonce
-	nonceSize := gcm.NonceSize()
-	if len(ciphertext) < nonceSize {
-		return fmt.Errorf("ciphertext too short")
-	}
-
-	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-
-	// Decrypt
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := credfile.Decrypt(ciphertext, passphrase)
 	if err != nil {
 		return fmt.Errorf("decryption failed: %w", err)
 	}
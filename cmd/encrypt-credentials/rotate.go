@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sausheong/pprox/internal/credfile"
+)
+
+// runRotate re-encrypts a credential file, legacy or envelope, under a new
+// passphrase and KDF. "migrate" is the same command with the passphrase
+// kept, for converting legacy files.
+func runRotate(name string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	inputFile := fs.String("input", "", "Encrypted credential file")
+	outputFile := fs.String("output", "", "Output file (default: replace the input)")
+	oldKey := fs.String("key", "", "Current passphrase")
+	newKey := fs.String("new-key", "", "New passphrase (default: keep the current one)")
+	kdf := fs.String("kdf", "argon2id", "Key derivation function: argon2id or scrypt")
+	fs.Parse(args)
+
+	if *inputFile == "" || *oldKey == "" {
+		fmt.Printf("Usage: ./encrypt-credentials %s -input creds.enc -key 'old' [-new-key 'new'] [-kdf argon2id|scrypt] [-output path]\n", name)
+		os.Exit(1)
+	}
+	if *newKey == "" {
+		*newKey = *oldKey
+	}
+	if *outputFile == "" {
+		*outputFile = *inputFile
+	}
+
+	params, err := credfile.ParseKDF(*kdf)
+	if err != nil {
+		fmt.Printf("Re-encryption failed: %v\n", err)
+		os.Exit(1)
+	}
+	if err := rotateFile(*inputFile, *outputFile, *oldKey, *newKey, params); err != nil {
+		fmt.Printf("Re-encryption failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Successfully re-encrypted %s to %s using %s\n", *inputFile, *outputFile, params.KDF)
+	if *newKey != *oldKey {
+		fmt.Println("\nIMPORTANT: Restart pprox with CREDENTIAL_ENCRYPTION_KEY set to the new passphrase.")
+		fmt.Println("Until then, reloads of this file fail and the previous credentials stay active.")
+	}
+}
+
+// rotateFile decrypts inputPath and writes it re-encrypted to outputPath
+func rotateFile(inputPath, outputPath, oldKey, newKey string, params credfile.Params) error {
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read encrypted file: %w", err)
+	}
+
+	plaintext, err := credfile.Decrypt(data, oldKey)
+	if err != nil {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+
+	ciphertext, err := credfile.Encrypt(plaintext, newKey, params)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(outputPath, ciphertext)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so a proxy watching the file never reads a partial write
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".creds-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write encrypted file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write encrypted file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write encrypted file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// readPassword reads a line from the terminal with echo disabled through stty.
+// If stty fails (e.g. input is not a terminal) the line is read as-is.
+func readPassword(fd int) ([]byte, error) {
+	disable := exec.Command("stty", "-echo")
+	disable.Stdin = os.Stdin
+	if err := disable.Run(); err != nil {
+		return readLine()
+	}
+
+	defer func() {
+		enable := exec.Command("stty", "echo")
+		enable.Stdin = os.Stdin
+		enable.Run()
+	}()
+
+	return readLine()
+}
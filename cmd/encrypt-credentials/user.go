@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sausheong/pprox/internal/credfile"
+	"github.com/sausheong/pprox/internal/scramkey"
+)
+
+// credentialDocument is a decrypted credential file. Users are kept as raw
+// JSON fields, so attributes this tool doesn't know about survive an edit.
+type credentialDocument struct {
+	fields map[string]json.RawMessage
+	users  []map[string]json.RawMessage
+}
+
+// runUser dispatches the user subcommands, which edit an encrypted credential
+// file in memory and never write plaintext to disk
+func runUser(args []string) {
+	if len(args) == 0 {
+		printUserUsage()
+		os.Exit(1)
+	}
+
+	command := args[0]
+	fs := flag.NewFlagSet("user "+command, flag.ExitOnError)
+	file := fs.String("file", "", "Encrypted credential file")
+	key := fs.String("key", "", "Encryption passphrase (default: CREDENTIAL_ENCRYPTION_KEY, or prompt)")
+	username := fs.String("user", "", "Username")
+	plaintext := fs.Bool("plaintext", false, "Store the password itself instead of a SCRAM-SHA-256 verifier")
+	iterations := fs.Int("iterations", scramkey.DefaultIterations, "PBKDF2 iteration count of the verifier (at least 4096)")
+	readOnly := fs.Bool("read-only", false, "Reject writes from the user (add only)")
+	databases := fs.String("databases", "", "Comma-separated databases the user may connect to (add only)")
+	maxConnections := fs.Int("max-connections", 0, "Concurrent sessions, 0 for unlimited (add only)")
+	routing := fs.String("routing", "", "Set to writer to send reads to the primary writer (add only)")
+	fs.Parse(args[1:])
+
+	if *file == "" || (command != "list" && *username == "") {
+		printUserUsage()
+		os.Exit(1)
+	}
+	if *iterations < scramkey.MinIterations {
+		fmt.Printf("Invalid -iterations %d: must be at least %d\n", *iterations, scramkey.MinIterations)
+		os.Exit(1)
+	}
+	if *routing != "" && *routing != "reader" && *routing != "writer" {
+		fmt.Printf("Invalid -routing %q: use reader or writer\n", *routing)
+		os.Exit(1)
+	}
+
+	// Attributes are only set when a user is added
+	if command != "add" {
+		addOnly := map[string]bool{"read-only": true, "databases": true, "max-connections": true, "routing": true}
+		fs.Visit(func(f *flag.Flag) {
+			if addOnly[f.Name] {
+				fmt.Printf("-%s can only be used with user add\n", f.Name)
+				os.Exit(1)
+			}
+		})
+	}
+
+	// A passphrase for a new file is typed twice, as a typo would lock the file
+	_, statErr := os.Stat(*file)
+	creating := command == "add" && errors.Is(statErr, os.ErrNotExist)
+
+	passphrase, err := encryptionPassphrase(*key, creating)
+	if err != nil {
+		fmt.Printf("Failed to read passphrase: %v\n", err)
+		os.Exit(1)
+	}
+
+	doc, params, err := readCredentialDocument(*file, passphrase, command == "add")
+	if err != nil {
+		fmt.Printf("Failed to open %s: %v\n", *file, err)
+		os.Exit(1)
+	}
+
+	switch command {
+	case "list":
+		doc.list()
+		return
+
+	case "add":
+		if doc.find(*username) >= 0 {
+			fmt.Printf("User %s already exists; use user passwd to change the password\n", *username)
+			os.Exit(1)
+		}
+		password, err := newPasswordValue(*plaintext, *iterations)
+		if err != nil {
+			fmt.Printf("Failed to set password: %v\n", err)
+			os.Exit(1)
+		}
+		user := map[string]json.RawMessage{
+			"username": mustMarshal(*username),
+			"password": mustMarshal(password),
+		}
+		if *readOnly {
+			user["read_only"] = mustMarshal(true)
+		}
+		if *databases != "" {
+			user["allowed_databases"] = mustMarshal(splitList(*databases))
+		}
+		if *maxConnections > 0 {
+			user["max_connections"] = mustMarshal(*maxConnections)
+		}
+		if *routing != "" {
+			user["default_routing"] = mustMarshal(*routing)
+		}
+		doc.users = append(doc.users, user)
+
+	case "remove":
+		i := doc.find(*username)
+		if i < 0 {
+			fmt.Printf("User %s not found\n", *username)
+			os.Exit(1)
+		}
+		doc.users = append(doc.users[:i], doc.users[i+1:]...)
+
+	case "passwd":
+		i := doc.find(*username)
+		if i < 0 {
+			fmt.Printf("User %s not found\n", *username)
+			os.Exit(1)
+		}
+		password, err := newPasswordValue(*plaintext, *iterations)
+		if err != nil {
+			fmt.Printf("Failed to set password: %v\n", err)
+			os.Exit(1)
+		}
+		doc.users[i]["password"] = mustMarshal(password)
+
+	default:
+		printUserUsage()
+		os.Exit(1)
+	}
+
+	if err := writeCredentialDocument(*file, passphrase, params, doc); err != nil {
+		fmt.Printf("Failed to update %s: %v\n", *file, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Updated %s (%d users)\n", *file, len(doc.users))
+}
+
+func printUserUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("  ./encrypt-credentials user list   -file creds.enc [-key 'passphrase']")
+	fmt.Println("  ./encrypt-credentials user add    -file creds.enc -user alice [-read-only] [-databases app,reports] [-max-connections 5] [-routing writer] [-plaintext]")
+	fmt.Println("  ./encrypt-credentials user passwd -file creds.enc -user alice [-plaintext]")
+	fmt.Println("  ./encrypt-credentials user remove -file creds.enc -user alice")
+	fmt.Println("Passwords are prompted for without echo. The passphrase defaults to CREDENTIAL_ENCRYPTION_KEY.")
+}
+
+// encryptionPassphrase returns the -key flag, CREDENTIAL_ENCRYPTION_KEY or a
+// prompted passphrase, asking for it twice if confirm is set
+func encryptionPassphrase(flagValue string, confirm bool) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if env := os.Getenv("CREDENTIAL_ENCRYPTION_KEY"); env != "" {
+		return env, nil
+	}
+
+	passphrase, err := promptPassword("Encryption passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if len(passphrase) == 0 {
+		return "", errors.New("passphrase must not be empty")
+	}
+	if confirm {
+		again, err := promptPassword("Confirm encryption passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(passphrase, again) {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	return string(passphrase), nil
+}
+
+// newPasswordValue prompts for a password and returns what to store for it
+func newPasswordValue(plaintext bool, iterations int) (string, error) {
+	password, err := promptNewPassword()
+	if err != nil {
+		return "", err
+	}
+	if plaintext {
+		return string(password), nil
+	}
+	return generateVerifier(password, iterations)
+}
+
+// readCredentialDocument decrypts and parses a credential file, returning the
+// KDF parameters to re-encrypt it with. A missing file is an empty document
+// if create is set.
+func readCredentialDocument(path, passphrase string, create bool) (*credentialDocument, credfile.Params, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && create {
+		return &credentialDocument{fields: make(map[string]json.RawMessage)}, credfile.DefaultArgon2id, nil
+	}
+	if err != nil {
+		return nil, credfile.Params{}, err
+	}
+
+	// Legacy files are upgraded to the envelope format when written back
+	params, err := credfile.Inspect(data)
+	if err != nil {
+		params = credfile.DefaultArgon2id
+	}
+
+	plaintext, err := credfile.Decrypt(data, passphrase)
+	if err != nil {
+		return nil, credfile.Params{}, fmt.Errorf("decryption failed: %w", err)
+	}
+
+	doc := &credentialDocument{}
+	if err := json.Unmarshal(plaintext, &doc.fields); err != nil {
+		return nil, credfile.Params{}, fmt.Errorf("failed to parse credential file: %w", err)
+	}
+	if doc.fields == nil {
+		doc.fields = make(map[string]json.RawMessage)
+	}
+	if users, ok := doc.fields["users"]; ok {
+		if err := json.Unmarshal(users, &doc.users); err != nil {
+			return nil, credfile.Params{}, fmt.Errorf("failed to parse users: %w", err)
+		}
+	} else if len(doc.fields) > 0 {
+		return nil, credfile.Params{}, errors.New(`not a credential file: no "users" list`)
+	}
+
+	return doc, params, nil
+}
+
+// writeCredentialDocument encrypts the document and atomically replaces path
+func writeCredentialDocument(path, passphrase string, params credfile.Params, doc *credentialDocument) error {
+	users := doc.users
+	if users == nil {
+		users = []map[string]json.RawMessage{}
+	}
+	doc.fields["users"] = mustMarshal(users)
+
+	plaintext, err := json.MarshalIndent(doc.fields, "", "  ")
+	if err != nil {
+		return err
+	}
+	ciphertext, err := credfile.Encrypt(plaintext, passphrase, params)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, ciphertext)
+}
+
+// find returns the index of a user, or -1
+func (d *credentialDocument) find(username string) int {
+	for i, user := range d.users {
+		var name string
+		if json.Unmarshal(user["username"], &name) == nil && name == username {
+			return i
+		}
+	}
+	return -1
+}
+
+// list prints users with their password type and attributes, never the passwords
+func (d *credentialDocument) list() {
+	for _, user := range d.users {
+		var name, password string
+		json.Unmarshal(user["username"], &name)
+		json.Unmarshal(user["password"], &password)
+
+		kind := "password"
+		if strings.HasPrefix(password, "SCRAM-SHA-256$") {
+			kind = "verifier"
+		}
+
+		var attributes []string
+		for field, value := range user {
+			switch field {
+			case "username", "password":
+			case "backend":
+				// Holds backend passwords
+				attributes = append(attributes, "backend=(set)")
+			default:
+				var compact bytes.Buffer
+				json.Compact(&compact, value)
+				attributes = append(attributes, field+"="+compact.String())
+			}
+		}
+		sort.Strings(attributes)
+
+		fmt.Printf("%s\t%s\t%s\n", name, kind, strings.Join(attributes, " "))
+	}
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// mustMarshal encodes values that can't fail to marshal
+func mustMarshal(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sausheong/pprox/internal/scramkey"
+)
+
+// runVerifier prompts for a password and prints its SCRAM-SHA-256 verifier
+func runVerifier(args []string) {
+	fs := flag.NewFlagSet("verifier", flag.ExitOnError)
+	iterations := fs.Int("iterations", scramkey.DefaultIterations, "PBKDF2 iteration count (at least 4096)")
+	username := fs.String("user", "", "Username to print in username:verifier form (optional)")
+	fs.Parse(args)
+
+	if *iterations < scramkey.MinIterations {
+		fmt.Printf("Invalid -iterations %d: must be at least %d\n", *iterations, scramkey.MinIterations)
+		os.Exit(1)
+	}
+
+	password, err := promptNewPassword()
+	if err != nil {
+		fmt.Printf("Failed to read password: %v\n", err)
+		os.Exit(1)
+	}
+
+	verifier, err := generateVerifier(password, *iterations)
+	if err != nil {
+		fmt.Printf("Failed to generate verifier: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *username != "" {
+		fmt.Printf("%s:%s\n", *username, verifier)
+	} else {
+		fmt.Println(verifier)
+	}
+}
+
+// promptNewPassword reads a password twice without echo and checks both entries match
+func promptNewPassword() ([]byte, error) {
+	password, err := promptPassword("Password: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(password) == 0 {
+		return nil, fmt.Errorf("password must not be empty")
+	}
+
+	confirm, err := promptPassword("Confirm password: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(password, confirm) {
+		return nil, fmt.Errorf("passwords do not match")
+	}
+
+	return password, nil
+}
+
+// promptPassword prints a prompt to stderr and reads a line from stdin without echo
+func promptPassword(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := readPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return password, err
+}
+
+// readLine reads a single line from stdin byte by byte, so nothing past the newline is consumed
+func readLine() ([]byte, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n == 1 {
+			if buf[0] == '\n' {
+				break
+			}
+			if buf[0] != '\r' {
+				line = append(line, buf[0])
+			}
+			continue
+		}
+		if err != nil {
+			if len(line) > 0 {
+				break
+			}
+			return nil, err
+		}
+	}
+	return line, nil
+}
+
+// generateVerifier builds a verifier in PostgreSQL's pg_authid format
+func generateVerifier(password []byte, iterations int) (string, error) {
+	return scramkey.Verifier(string(password), iterations)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sausheong/pprox/internal/credfile"
+)
+
+// Merge policies for users defined by more than one source
+const (
+	MergeFirstWins = "first-wins" // The earliest source in the list defines the user
+	MergeOverride  = "override"   // Later sources replace earlier definitions
+)
+
+// namedProvider is a source of a composite provider
+type namedProvider struct {
+	name     string
+	provider CredentialProvider
+	remote   bool                  // Served from the snapshot when down at startup
+	last     map[string]*UserEntry // Last credentials loaded from the source
+	loadedAt time.Time             // When the source last confirmed last, or the snapshot time
+}
+
+// snapshotEntry is the snapshot of one remote source
+type snapshotEntry struct {
+	SavedAt time.Time             `json:"saved_at"`
+	Users   map[string]*UserEntry `json:"users"`
+}
+
+// remoteSources are the sources covered by snapshots. Local sources are not:
+// removing a break-glass file must not be undone by an old snapshot.
+var remoteSources = map[string]bool{"vault": true, "aws": true, "http": true}
+
+// CompositeCredentialProvider merges the credentials of several sources, e.g.
+// a local break-glass file and Vault. Each source keeps its last good
+// credentials when it fails, and a remote source that is down at startup is
+// served from an encrypted snapshot of its last successful load.
+type CompositeCredentialProvider struct {
+	sources        []*namedProvider
+	merge          string
+	snapshotPath   string // Empty to disable snapshots
+	snapshotKey    string
+	snapshotMaxAge time.Duration // Oldest snapshot entry still served, 0 for no limit
+
+	pending    bool      // A remote source changed since the snapshot was written
+	snapshotAt time.Time // When this process last wrote the snapshot
+}
+
+// NewCompositeCredentialProvider creates a provider over sources, in priority order
+func NewCompositeCredentialProvider(names []string, providers []CredentialProvider, merge, snapshotPath, snapshotKey string, snapshotMaxAge time.Duration) *CompositeCredentialProvider {
+	p := &CompositeCredentialProvider{
+		merge:          merge,
+		snapshotPath:   snapshotPath,
+		snapshotKey:    snapshotKey,
+		snapshotMaxAge: snapshotMaxAge,
+	}
+	for i, provider := range providers {
+		p.sources = append(p.sources, &namedProvider{name: names[i], provider: provider, remote: remoteSources[names[i]]})
+	}
+	return p
+}
+
+// GetCredentials loads every source and merges the results. It fails only if
+// no source could be loaded, live or from the snapshot.
+func (p *CompositeCredentialProvider) GetCredentials(ctx context.Context) (map[string]*UserEntry, error) {
+	changed := false
+	loaded := 0
+	var snapshot map[string]*snapshotEntry
+	var failures []string
+
+	for _, src := range p.sources {
+		credentials, err := src.provider.GetCredentials(ctx)
+		switch {
+		case err == nil:
+			src.last = credentials
+			src.loadedAt = time.Now()
+			changed = true
+			if src.remote {
+				p.pending = true
+			}
+		case errors.Is(err, ErrCredentialsNotModified) && src.last != nil:
+			src.loadedAt = time.Now()
+		case src.last != nil:
+			log.Printf("Credential source %s failed, keeping its previous credentials: %v", src.name, err)
+		case src.remote:
+			if snapshot == nil {
+				snapshot = p.readSnapshot()
+			}
+			if entry, ok := snapshot[src.name]; ok {
+				log.Printf("Credential source %s failed, using its snapshot from %s: %v", src.name, entry.SavedAt.Format(time.RFC3339), err)
+				src.last = entry.Users
+				src.loadedAt = entry.SavedAt
+				changed = true
+			} else {
+				log.Printf("Credential source %s failed and has no snapshot, skipping it: %v", src.name, err)
+				failures = append(failures, fmt.Sprintf("%s: %v", src.name, err))
+				continue
+			}
+		default:
+			log.Printf("Credential source %s failed, skipping it: %v", src.name, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", src.name, err))
+			continue
+		}
+		loaded++
+	}
+
+	if loaded == 0 {
+		return nil, fmt.Errorf("all credential sources failed: %s", strings.Join(failures, "; "))
+	}
+	if !changed {
+		// Unchanged credentials were applied before, so the snapshot can be
+		// re-dated before its entries age out
+		if !p.pending && p.snapshotMaxAge > 0 && time.Since(p.snapshotAt) > p.snapshotMaxAge/2 {
+			p.saveSnapshot()
+		}
+		return nil, ErrCredentialsNotModified
+	}
+
+	return p.merged(), nil
+}
+
+// CommitCredentials writes the snapshot once the manager has validated and
+// applied credentials with new data from a remote source, and passes the
+// commit on to the sources
+func (p *CompositeCredentialProvider) CommitCredentials() {
+	for _, src := range p.sources {
+		if cp, ok := src.provider.(CommittingProvider); ok {
+			cp.CommitCredentials()
+		}
+	}
+	if p.pending {
+		p.saveSnapshot()
+	}
+}
+
+// saveSnapshot writes the snapshot, logging failures
+func (p *CompositeCredentialProvider) saveSnapshot() {
+	if err := p.writeSnapshot(); err != nil {
+		log.Printf("Failed to write credential snapshot: %v", err)
+		return
+	}
+	p.pending = false
+	p.snapshotAt = time.Now()
+}
+
+// merged combines the last credentials of all sources by the merge policy
+func (p *CompositeCredentialProvider) merged() map[string]*UserEntry {
+	credentials := make(map[string]*UserEntry)
+	for _, src := range p.sources {
+		for username, entry := range src.last {
+			if _, exists := credentials[username]; exists && p.merge == MergeFirstWins {
+				continue
+			}
+			credentials[username] = entry
+		}
+	}
+	return credentials
+}
+
+// SupportsReload reports whether any source can change at runtime
+func (p *CompositeCredentialProvider) SupportsReload() bool {
+	for _, src := range p.sources {
+		if src.provider.SupportsReload() {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchPaths returns the files of all watchable sources
+func (p *CompositeCredentialProvider) WatchPaths() []string {
+	var paths []string
+	for _, src := range p.sources {
+		if wp, ok := src.provider.(WatchableProvider); ok {
+			paths = append(paths, wp.WatchPaths()...)
+		}
+	}
+	return paths
+}
+
+// readSnapshot returns the snapshot entries that are not older than the
+// maximum age, or nil
+func (p *CompositeCredentialProvider) readSnapshot() map[string]*snapshotEntry {
+	if p.snapshotPath == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(p.snapshotPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to read credential snapshot: %v", err)
+		}
+		return nil
+	}
+	plaintext, err := credfile.Decrypt(data, p.snapshotKey)
+	if err != nil {
+		log.Printf("Failed to decrypt credential snapshot: %v", err)
+		return nil
+	}
+
+	var snapshot map[string]*snapshotEntry
+	if err := json.Unmarshal(plaintext, &snapshot); err != nil {
+		log.Printf("Failed to parse credential snapshot: %v", err)
+		return nil
+	}
+
+	for name, entry := range snapshot {
+		switch {
+		case entry == nil || entry.SavedAt.IsZero() || entry.Users == nil:
+			log.Printf("Ignoring credential snapshot of %s: no timestamp or users", name)
+			delete(snapshot, name)
+		case p.snapshotMaxAge > 0 && time.Since(entry.SavedAt) > p.snapshotMaxAge:
+			log.Printf("Ignoring credential snapshot of %s: saved %s, older than %s", name, entry.SavedAt.Format(time.RFC3339), p.snapshotMaxAge)
+			delete(snapshot, name)
+		}
+	}
+	return snapshot
+}
+
+// writeSnapshot atomically replaces the snapshot with the last credentials of
+// the remote sources, each dated when its source last confirmed them
+func (p *CompositeCredentialProvider) writeSnapshot() error {
+	if p.snapshotPath == "" {
+		return nil
+	}
+
+	snapshot := make(map[string]*snapshotEntry)
+	for _, src := range p.sources {
+		if src.remote && src.last != nil {
+			snapshot[src.name] = &snapshotEntry{SavedAt: src.loadedAt, Users: src.last}
+		}
+	}
+	plaintext, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	data, err := credfile.Encrypt(plaintext, p.snapshotKey, credfile.DefaultArgon2id)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p.snapshotPath), ".snapshot-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p.snapshotPath)
+}
+
+// loadCompositeProvider creates a composite provider over a CREDENTIAL_SOURCE list
+func loadCompositeProvider(names []string) (*CompositeCredentialProvider, error) {
+	merge := os.Getenv("CREDENTIAL_MERGE")
+	switch merge {
+	case "":
+		merge = MergeFirstWins
+	case MergeFirstWins, MergeOverride:
+	default:
+		return nil, fmt.Errorf("invalid CREDENTIAL_MERGE: %s (use %s or %s)", merge, MergeFirstWins, MergeOverride)
+	}
+
+	providers := make([]CredentialProvider, len(names))
+	seen := make(map[string]bool)
+	for i, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("credential source %s listed twice", name)
+		}
+		seen[name] = true
+
+		provider, err := createSourceProvider(name)
+		if err != nil {
+			return nil, err
+		}
+		providers[i] = provider
+	}
+
+	snapshotPath := os.Getenv("CREDENTIAL_SNAPSHOT_FILE")
+	snapshotKey := os.Getenv("CREDENTIAL_SNAPSHOT_KEY")
+	if snapshotKey == "" {
+		snapshotKey = os.Getenv("CREDENTIAL_ENCRYPTION_KEY")
+	}
+	if snapshotPath != "" && snapshotKey == "" {
+		return nil, fmt.Errorf("CREDENTIAL_SNAPSHOT_KEY or CREDENTIAL_ENCRYPTION_KEY must be set when CREDENTIAL_SNAPSHOT_FILE is set")
+	}
+
+	snapshotMaxAge := 7 * 24 * time.Hour
+	if v := os.Getenv("CREDENTIAL_SNAPSHOT_MAX_AGE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid CREDENTIAL_SNAPSHOT_MAX_AGE: %s", v)
+		}
+		snapshotMaxAge = d
+	}
+
+	return NewCompositeCredentialProvider(names, providers, merge, snapshotPath, snapshotKey, snapshotMaxAge), nil
+}
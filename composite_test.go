@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// stubProvider returns fixed credentials or an error
+type stubProvider struct {
+	credentials map[string]*UserEntry
+	err         error
+}
+
+func (s *stubProvider) GetCredentials(ctx context.Context) (map[string]*UserEntry, error) {
+	return s.credentials, s.err
+}
+
+func (s *stubProvider) SupportsReload() bool {
+	return true
+}
+
+func newSnapshotTest(t *testing.T, maxAge time.Duration) (*stubProvider, *stubProvider, *CompositeCredentialProvider) {
+	local := &stubProvider{credentials: map[string]*UserEntry{"admin": {Password: "admin-password"}}}
+	vault := &stubProvider{credentials: map[string]*UserEntry{"alice": {Password: "alice-password"}}}
+	path := filepath.Join(t.TempDir(), "credentials.snapshot")
+	provider := NewCompositeCredentialProvider([]string{"file", "vault"}, []CredentialProvider{local, vault}, MergeFirstWins, path, "snapshot-key", maxAge)
+	return local, vault, provider
+}
+
+func TestSnapshotWrittenAfterValidation(t *testing.T) {
+	_, vault, provider := newSnapshotTest(t, time.Hour)
+
+	vault.credentials = map[string]*UserEntry{"alice": {Password: "alice-password", Attributes: UserAttributes{MaxConnections: -1}}}
+	if err := NewCredentialManager(provider).LoadCredentials(context.Background()); err == nil {
+		t.Fatal("LoadCredentials() with invalid attributes succeeded")
+	}
+	if _, err := os.Stat(provider.snapshotPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("snapshot written for credentials that failed validation: %v", err)
+	}
+}
+
+func TestSnapshotNotWrittenForLocalChanges(t *testing.T) {
+	local, vault, provider := newSnapshotTest(t, time.Hour)
+	cm := NewCredentialManager(provider)
+	ctx := context.Background()
+
+	if err := cm.LoadCredentials(ctx); err != nil {
+		t.Fatalf("LoadCredentials() error = %v", err)
+	}
+	before, err := os.ReadFile(provider.snapshotPath)
+	if err != nil {
+		t.Fatalf("snapshot not written after a valid load: %v", err)
+	}
+
+	vault.err = ErrCredentialsNotModified
+	local.credentials = map[string]*UserEntry{"admin": {Password: "new-password"}}
+	if err := cm.LoadCredentials(ctx); err != nil {
+		t.Fatalf("LoadCredentials() after a local change error = %v", err)
+	}
+	after, err := os.ReadFile(provider.snapshotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Error("snapshot rewritten for a local-only change")
+	}
+}
+
+func TestSnapshotServesRemoteSourceUntilMaxAge(t *testing.T) {
+	_, vault, provider := newSnapshotTest(t, time.Hour)
+	ctx := context.Background()
+	if err := NewCredentialManager(provider).LoadCredentials(ctx); err != nil {
+		t.Fatalf("LoadCredentials() error = %v", err)
+	}
+
+	// A restarted proxy with Vault down uses the snapshot
+	vault.err = errors.New("vault unavailable")
+	restarted := NewCompositeCredentialProvider([]string{"file", "vault"}, []CredentialProvider{provider.sources[0].provider, vault}, MergeFirstWins, provider.snapshotPath, "snapshot-key", time.Hour)
+	credentials, err := restarted.GetCredentials(ctx)
+	if err != nil {
+		t.Fatalf("GetCredentials() error = %v", err)
+	}
+	if _, ok := credentials["alice"]; !ok {
+		t.Error("snapshot not used for a failed remote source")
+	}
+
+	// Past the max age the snapshot is ignored
+	expired := NewCompositeCredentialProvider([]string{"file", "vault"}, []CredentialProvider{provider.sources[0].provider, vault}, MergeFirstWins, provider.snapshotPath, "snapshot-key", time.Nanosecond)
+	credentials, err = expired.GetCredentials(ctx)
+	if err != nil {
+		t.Fatalf("GetCredentials() error = %v", err)
+	}
+	if _, ok := credentials["alice"]; ok {
+		t.Error("expired snapshot used for a failed remote source")
+	}
+}
@@ -5,19 +5,32 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
 
 // Config holds the proxy configuration
 type Config struct {
-	ProxyAddr     string
-	ReaderDSN     string
-	WriterDSNs    []string
-	TLSConfig     *TLSConfig
-	AuthConfig    *AuthConfig
-	BackendTLS    *BackendTLSConfig
+	ProxyAddr              string
+	ReaderDSN              string
+	WriterDSNs             []string
+	PrimaryWriter          int                      // Index of the writer whose results are returned to clients
+	VerifyReturning        bool                     // Verify that all writers return identical RETURNING rows
+	NotifyDSN              string                   // Backend used for LISTEN/NOTIFY
+	NotifyBackend          string                   // Name of that backend: notify, or the primary writer's
+	StatementTimeout       time.Duration            // Default deadline for each query, 0 for none
+	UserStatementTimeouts  map[string]time.Duration // Per-user overrides of StatementTimeout
+	TLSConfig              *TLSConfig
+	Credentials            *CredentialManager // Source of the current AuthConfig, reloaded at runtime
+	DisconnectRemovedUsers bool               // Terminate sessions of users removed by a credential reload
+	BackendTLS             *BackendTLSConfig
+	HBA                    *HBAManager      // Host-based access rules, nil to allow every connection
+	AuthQuery              *AuthQueryConfig // Verifier lookup from the database, nil if disabled
+	AuthLockout            *AuthLockoutConfig
+	MetricsAddr            string // Serves expvar counters on /debug/vars, empty to disable
 }
 
 // TLSConfig holds TLS configuration for client connections
@@ -26,16 +39,30 @@ type TLSConfig struct {
 	CertFile string
 	KeyFile  string
 	TLS      *tls.Config
+
+	Certificates *CertificateReloader // Serves the current key pair through TLS.GetCertificate
+
+	// Client certificate (mTLS) authentication
+	ClientAuth         string    // none, optional, require
+	ClientCAFile       string    // CA bundle used to verify client certificates
+	IdentMap           *IdentMap // Maps certificate names to users, nil to require CN == user
+	IdentMapName       string    // Map name used in the ident file
+	CertSkipSCRAMUsers []string  // Users for whom a valid certificate replaces SCRAM ("*" for all)
 }
 
 // BackendTLSConfig holds TLS configuration for backend database connections
 type BackendTLSConfig struct {
-	Enabled            bool
-	Mode               string // disable, require, verify-ca, verify-full
-	RootCAFile         string
-	ClientCertFile     string
-	ClientKeyFile      string
-	TLS                *tls.Config
+	Enabled        bool
+	Mode           string // disable, require, verify-ca, verify-full
+	RootCAFile     string
+	ClientCertFile string
+	ClientKeyFile  string
+	ServerName     string // SNI and verify-full host name, default: the DSN's host
+	CRLFile        string // Comma-separated CRL files checked in verify modes
+	OCSP           string // off, check, require: handling of stapled OCSP responses
+	TLS            *tls.Config
+
+	Backends map[string]*BackendTLSConfig // Per-backend overrides by backend name
 }
 
 // LoadConfig loads configuration from environment variables
@@ -60,6 +87,41 @@ func LoadConfig() (*Config, error) {
 		writerDSNs[i] = strings.TrimSpace(writerDSNs[i])
 	}
 
+	// Primary writer whose RETURNING rows are sent back to clients
+	primaryWriter := 0
+	if v := os.Getenv("PG_PRIMARY_WRITER"); v != "" {
+		idx, err := strconv.Atoi(v)
+		if err != nil || idx < 0 || idx >= len(writerDSNs) {
+			return nil, fmt.Errorf("invalid PG_PRIMARY_WRITER: %s (must be an index into PG_WRITERS_CSV)", v)
+		}
+		primaryWriter = idx
+	}
+
+	verifyReturning := os.Getenv("VERIFY_RETURNING_ROWS")
+
+	// LISTEN/NOTIFY must use a single backend, defaulting to the primary writer
+	notifyDSN := os.Getenv("PG_NOTIFY_DSN")
+	notifyBackend := "notify"
+	if notifyDSN == "" {
+		notifyDSN = writerDSNs[primaryWriter]
+		notifyBackend = writerBackendName(primaryWriter)
+	}
+
+	// Statement deadlines
+	var statementTimeout time.Duration
+	if v := os.Getenv("STATEMENT_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STATEMENT_TIMEOUT: %w", err)
+		}
+		statementTimeout = d
+	}
+
+	userStatementTimeouts, err := parseUserStatementTimeouts(os.Getenv("USER_STATEMENT_TIMEOUTS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid USER_STATEMENT_TIMEOUTS: %w", err)
+	}
+
 	// Load TLS configuration
 	tlsConfig, err := loadTLSConfig()
 	if err != nil {
@@ -67,27 +129,214 @@ func LoadConfig() (*Config, error) {
 	}
 
 	// Load authentication configuration
-	authConfig, err := loadAuthConfig()
+	credentials, err := loadCredentialManager()
 	if err != nil {
 		return nil, fmt.Errorf("failed to load auth config: %w", err)
 	}
 
+	// Load auth query configuration
+	authQuery, err := loadAuthQueryConfig(writerDSNs[primaryWriter], writerBackendName(primaryWriter))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load auth query config: %w", err)
+	}
+
 	// Load backend TLS configuration
-	backendTLS, err := loadBackendTLSConfig()
+	backendDSNs := map[string]string{"reader": readerDSN}
+	for i, dsn := range writerDSNs {
+		backendDSNs[writerBackendName(i)] = dsn
+	}
+	if notifyBackend == "notify" {
+		backendDSNs["notify"] = notifyDSN
+	}
+	backendTLS, err := loadBackendTLSConfig(backendDSNs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load backend TLS config: %w", err)
 	}
+	if authQuery != nil && authQuery.Backend == "" {
+		if err := backendTLS.checkDSN("AUTH_QUERY_DSN", "", authQuery.DSN); err != nil {
+			return nil, fmt.Errorf("failed to load backend TLS config: %w", err)
+		}
+	}
+
+	// Load brute-force protection settings
+	authLockout, err := loadAuthLockoutConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load auth lockout config: %w", err)
+	}
+
+	// Load host-based access rules
+	hba, err := loadHBAManager()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load HBA rules: %w", err)
+	}
 
 	return &Config{
-		ProxyAddr:  proxyAddr,
-		ReaderDSN:  readerDSN,
-		WriterDSNs: writerDSNs,
-		TLSConfig:  tlsConfig,
-		AuthConfig: authConfig,
-		BackendTLS: backendTLS,
+		ProxyAddr:              proxyAddr,
+		ReaderDSN:              readerDSN,
+		WriterDSNs:             writerDSNs,
+		PrimaryWriter:          primaryWriter,
+		VerifyReturning:        verifyReturning == "true" || verifyReturning == "1",
+		NotifyDSN:              notifyDSN,
+		NotifyBackend:          notifyBackend,
+		StatementTimeout:       statementTimeout,
+		UserStatementTimeouts:  userStatementTimeouts,
+		TLSConfig:              tlsConfig,
+		Credentials:            credentials,
+		DisconnectRemovedUsers: os.Getenv("DISCONNECT_REMOVED_USERS") == "true" || os.Getenv("DISCONNECT_REMOVED_USERS") == "1",
+		BackendTLS:             backendTLS,
+		HBA:                    hba,
+		AuthQuery:              authQuery,
+		AuthLockout:            authLockout,
+		MetricsAddr:            os.Getenv("METRICS_ADDR"),
 	}, nil
 }
 
+// loadAuthQueryConfig loads the auth query settings; the query runs on the primary writer by default
+func loadAuthQueryConfig(defaultDSN, defaultBackend string) (*AuthQueryConfig, error) {
+	query := os.Getenv("AUTH_QUERY")
+	if query == "" {
+		return nil, nil
+	}
+
+	config := &AuthQueryConfig{
+		Query:          query,
+		DSN:            os.Getenv("AUTH_QUERY_DSN"),
+		CacheTTL:       time.Minute,
+		CacheSize:      10000,
+		MaxConnections: 2,
+	}
+	if config.DSN == "" {
+		config.DSN = defaultDSN
+		config.Backend = defaultBackend
+	}
+
+	if v := os.Getenv("AUTH_QUERY_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid AUTH_QUERY_CACHE_TTL: %s", v)
+		}
+		config.CacheTTL = d
+	}
+
+	if v := os.Getenv("AUTH_QUERY_CACHE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid AUTH_QUERY_CACHE_SIZE: %s", v)
+		}
+		config.CacheSize = n
+	}
+
+	if v := os.Getenv("AUTH_QUERY_MAX_CONNECTIONS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid AUTH_QUERY_MAX_CONNECTIONS: %s", v)
+		}
+		config.MaxConnections = n
+	}
+
+	return config, nil
+}
+
+// loadAuthLockoutConfig loads brute-force protection settings from environment
+func loadAuthLockoutConfig() (*AuthLockoutConfig, error) {
+	config := &AuthLockoutConfig{
+		MaxFailures:     5,
+		MaxIPFailures:   20,
+		LockoutDuration: 15 * time.Minute,
+		BackoffBase:     200 * time.Millisecond,
+		BackoffMax:      5 * time.Second,
+	}
+
+	limits := []struct {
+		name  string
+		value *int
+	}{
+		{"AUTH_MAX_FAILURES", &config.MaxFailures},
+		{"AUTH_MAX_IP_FAILURES", &config.MaxIPFailures},
+	}
+	for _, l := range limits {
+		v := os.Getenv(l.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s: %s", l.name, v)
+		}
+		*l.value = n
+	}
+
+	durations := []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"AUTH_LOCKOUT_DURATION", &config.LockoutDuration},
+		{"AUTH_FAILURE_DELAY", &config.BackoffBase},
+		{"AUTH_FAILURE_DELAY_MAX", &config.BackoffMax},
+	}
+	for _, d := range durations {
+		v := os.Getenv(d.name)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid %s: %s", d.name, v)
+		}
+		*d.value = parsed
+	}
+
+	return config, nil
+}
+
+// loadHBAManager loads host-based access rules from HBA_FILE and watches it for changes
+func loadHBAManager() (*HBAManager, error) {
+	path := os.Getenv("HBA_FILE")
+	if path == "" {
+		return nil, nil
+	}
+
+	interval := 5 * time.Second
+	if v := os.Getenv("HBA_RELOAD_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid HBA_RELOAD_INTERVAL: %s", v)
+		}
+		interval = d
+	}
+
+	hba, err := NewHBAManager(path)
+	if err != nil {
+		return nil, err
+	}
+	hba.Watch(interval)
+
+	return hba, nil
+}
+
+// parseUserStatementTimeouts parses per-user statement deadlines
+// Format: username1:30s,username2:5m
+func parseUserStatementTimeouts(value string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	if value == "" {
+		return timeouts, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid format: %s", pair)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout for user %s: %w", parts[0], err)
+		}
+		timeouts[strings.TrimSpace(parts[0])] = d
+	}
+
+	return timeouts, nil
+}
+
 // loadTLSConfig loads TLS configuration from environment
 func loadTLSConfig() (*TLSConfig, error) {
 	tlsEnabled := os.Getenv("TLS_ENABLED")
@@ -102,15 +351,19 @@ func loadTLSConfig() (*TLSConfig, error) {
 		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE are required when TLS is enabled")
 	}
 
-	// Load certificate
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	// Load certificate; it's reloaded when the files change
+	certificates, err := NewCertificateReloader(certFile, keyFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		return nil, err
 	}
+	certificates.Watch()
 
 	tlsConf := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		MinVersion:   tls.VersionTLS12,
+		GetCertificate: certificates.GetCertificate,
+		// Resumed sessions skip GetCertificate, which channel binding relies
+		// on to know the certificate served on each connection
+		SessionTicketsDisabled: true,
+		MinVersion:             tls.VersionTLS12,
 		CipherSuites: []uint16{
 			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
 			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
@@ -119,16 +372,76 @@ func loadTLSConfig() (*TLSConfig, error) {
 		},
 	}
 
-	return &TLSConfig{
-		Enabled:  true,
-		CertFile: certFile,
-		KeyFile:  keyFile,
-		TLS:      tlsConf,
-	}, nil
+	config := &TLSConfig{
+		Enabled:      true,
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		TLS:          tlsConf,
+		Certificates: certificates,
+	}
+
+	if err := loadClientCertConfig(config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// loadClientCertConfig loads client certificate (mTLS) settings from environment
+func loadClientCertConfig(config *TLSConfig) error {
+	clientAuth := os.Getenv("TLS_CLIENT_AUTH")
+	if clientAuth == "" {
+		clientAuth = ClientCertNone
+	}
+	config.ClientAuth = clientAuth
+
+	switch clientAuth {
+	case ClientCertNone:
+		return nil
+	case ClientCertOptional:
+		config.TLS.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientCertRequire:
+		config.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return fmt.Errorf("invalid TLS_CLIENT_AUTH: %s (must be: none, optional, require)", clientAuth)
+	}
+
+	caFile := os.Getenv("TLS_CLIENT_CA_FILE")
+	if caFile == "" {
+		return fmt.Errorf("TLS_CLIENT_CA_FILE is required when TLS_CLIENT_AUTH=%s", clientAuth)
+	}
+	caCert, err := os.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	caCertPool := x509.NewCertPool()
+	if !caCertPool.AppendCertsFromPEM(caCert) {
+		return fmt.Errorf("failed to parse client CA certificate")
+	}
+	config.ClientCAFile = caFile
+	config.TLS.ClientCAs = caCertPool
+
+	if identFile := os.Getenv("TLS_CLIENT_IDENT_FILE"); identFile != "" {
+		identMap, err := LoadIdentMap(identFile)
+		if err != nil {
+			return err
+		}
+		config.IdentMap = identMap
+	}
+
+	config.IdentMapName = os.Getenv("TLS_CLIENT_IDENT_MAP")
+	if config.IdentMapName == "" {
+		config.IdentMapName = "cert"
+	}
+
+	config.CertSkipSCRAMUsers = parseUserList(os.Getenv("TLS_CLIENT_CERT_SKIP_SCRAM"))
+
+	return nil
 }
 
-// loadAuthConfig loads authentication configuration using credential providers
-func loadAuthConfig() (*AuthConfig, error) {
+// loadCredentialManager loads authentication configuration using credential providers.
+// The returned manager always holds the latest AuthConfig, including after reloads.
+func loadCredentialManager() (*CredentialManager, error) {
 	// Create credential provider based on configuration
 	provider, err := CreateCredentialProvider()
 	if err != nil {
@@ -143,22 +456,119 @@ func loadAuthConfig() (*AuthConfig, error) {
 		return nil, fmt.Errorf("failed to load credentials: %w", err)
 	}
 
-	// Start auto-reload if supported and enabled
-	reloadInterval := os.Getenv("CREDENTIAL_RELOAD_INTERVAL")
-	if reloadInterval != "" && provider.SupportsReload() {
-		// Parse interval (e.g., "5m", "1h")
-		// For now, default to 5 minutes if not parseable
-		// TODO: Add proper duration parsing
-		credManager.StartAutoReload(context.Background(), 5*time.Minute)
+	// File-based providers are watched for changes; the interval is a
+	// fallback for them and the only trigger for remote providers
+	var reloadInterval time.Duration
+	if v := os.Getenv("CREDENTIAL_RELOAD_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid CREDENTIAL_RELOAD_INTERVAL: %s", v)
+		}
+		reloadInterval = d
 	}
+	if v := os.Getenv("CREDENTIAL_WATCH"); v == "false" || v == "0" {
+		credManager.watchEnabled = false
+	}
+	credManager.StartAutoReload(context.Background(), reloadInterval)
 
-	return credManager.GetAuthConfig(), nil
+	return credManager, nil
 }
 
-// loadBackendTLSConfig loads backend TLS configuration from environment
-func loadBackendTLSConfig() (*BackendTLSConfig, error) {
-	mode := os.Getenv("BACKEND_TLS_MODE")
+// loadBackendTLSConfig loads backend TLS configuration from environment. The
+// BACKEND_TLS_* settings are the default; BACKEND_TLS_<BACKEND>_* overrides
+// them for one of the backends in dsns (READER, WRITER0, WRITER1, ... or
+// NOTIFY if PG_NOTIFY_DSN is set).
+func loadBackendTLSConfig(dsns map[string]string) (*BackendTLSConfig, error) {
+	if err := checkBackendTLSNames(dsns); err != nil {
+		return nil, err
+	}
+
+	config, err := loadBackendTLSSettings("BACKEND_TLS_", &BackendTLSConfig{Mode: "disable"})
+	if err != nil {
+		return nil, err
+	}
+
+	config.Backends = make(map[string]*BackendTLSConfig)
+	for name := range dsns {
+		prefix := "BACKEND_TLS_" + strings.ToUpper(name) + "_"
+		if !hasBackendTLSSettings(prefix) {
+			continue
+		}
+		settings, err := loadBackendTLSSettings(prefix, config)
+		if err != nil {
+			return nil, err
+		}
+		config.Backends[name] = settings
+	}
+
+	for name, dsn := range dsns {
+		if err := config.checkDSN(name, name, dsn); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+// checkBackendTLSNames rejects BACKEND_TLS_ variables that are not a known
+// setting, so a typo or a writer index past the end isn't silently ignored
+func checkBackendTLSNames(dsns map[string]string) error {
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		rest, ok := strings.CutPrefix(name, "BACKEND_TLS_")
+		if !ok {
+			continue
+		}
+
+		known := false
+		for _, key := range backendTLSKeys {
+			if rest == key {
+				known = true
+				break
+			}
+			if backend, ok := strings.CutSuffix(rest, "_"+key); ok {
+				if _, exists := dsns[strings.ToLower(backend)]; !exists {
+					return fmt.Errorf("%s: unknown backend %s (use READER, WRITER<n> for a configured writer, or NOTIFY with PG_NOTIFY_DSN set)", name, backend)
+				}
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown backend TLS setting %s", name)
+		}
+	}
+	return nil
+}
+
+// backendTLSKeys are the settings read for each BACKEND_TLS_ prefix
+var backendTLSKeys = []string{"MODE", "ROOT_CA", "CLIENT_CERT", "CLIENT_KEY", "SERVER_NAME", "CRL", "OCSP"}
+
+// hasBackendTLSSettings reports whether any setting with prefix is set
+func hasBackendTLSSettings(prefix string) bool {
+	for _, key := range backendTLSKeys {
+		if os.Getenv(prefix+key) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// loadBackendTLSSettings loads the settings with prefix, falling back to defaults for unset ones
+func loadBackendTLSSettings(prefix string, defaults *BackendTLSConfig) (*BackendTLSConfig, error) {
+	env := func(key, fallback string) string {
+		if v := os.Getenv(prefix + key); v != "" {
+			return v
+		}
+		return fallback
+	}
+
+	mode := env("MODE", defaults.Mode)
 	if mode == "" || mode == "disable" {
+		// Per-backend settings without a mode would silently be ignored
+		if prefix != "BACKEND_TLS_" && os.Getenv(prefix+"MODE") == "" {
+			return nil, fmt.Errorf("%sMODE is required when BACKEND_TLS_MODE is disable", prefix)
+		}
 		return &BackendTLSConfig{
 			Enabled: false,
 			Mode:    "disable",
@@ -172,38 +582,63 @@ func loadBackendTLSConfig() (*BackendTLSConfig, error) {
 		"verify-full": true,
 	}
 	if !validModes[mode] {
-		return nil, fmt.Errorf("invalid BACKEND_TLS_MODE: %s (must be: disable, require, verify-ca, verify-full)", mode)
+		return nil, fmt.Errorf("invalid %sMODE: %s (must be: disable, require, verify-ca, verify-full)", prefix, mode)
 	}
 
 	config := &BackendTLSConfig{
-		Enabled: true,
-		Mode:    mode,
+		Enabled:    true,
+		Mode:       mode,
+		ServerName: env("SERVER_NAME", defaults.ServerName),
 	}
 
 	// Load root CA certificate for verify-ca and verify-full modes
 	if mode == "verify-ca" || mode == "verify-full" {
-		rootCAFile := os.Getenv("BACKEND_TLS_ROOT_CA")
+		rootCAFile := env("ROOT_CA", defaults.RootCAFile)
 		if rootCAFile == "" {
-			return nil, fmt.Errorf("BACKEND_TLS_ROOT_CA is required for mode %s", mode)
+			return nil, fmt.Errorf("%sROOT_CA is required for mode %s", prefix, mode)
 		}
 		config.RootCAFile = rootCAFile
 	}
 
-	// Load client certificate and key (optional, for mutual TLS)
-	clientCertFile := os.Getenv("BACKEND_TLS_CLIENT_CERT")
-	clientKeyFile := os.Getenv("BACKEND_TLS_CLIENT_KEY")
-	
+	// Revocation checks need a verified chain
+	config.CRLFile = env("CRL", defaults.CRLFile)
+	config.OCSP = env("OCSP", defaults.OCSP)
+	switch config.OCSP {
+	case "":
+		config.OCSP = OCSPOff
+	case OCSPOff, OCSPCheck, OCSPRequire:
+	default:
+		return nil, fmt.Errorf("invalid %sOCSP: %s (must be: off, check, require)", prefix, config.OCSP)
+	}
+	if mode == "require" && (os.Getenv(prefix+"CRL") != "" || os.Getenv(prefix+"OCSP") != "") {
+		return nil, fmt.Errorf("%sCRL and %sOCSP require mode verify-ca or verify-full", prefix, prefix)
+	}
+	if mode == "require" && (config.CRLFile != "" || config.OCSP != OCSPOff) {
+		// Settings inherited from BACKEND_TLS_* can't be unset for one backend
+		log.Printf("%sMODE is require, so the inherited BACKEND_TLS_CRL and BACKEND_TLS_OCSP settings are ignored for it", prefix)
+		config.CRLFile = ""
+		config.OCSP = OCSPOff
+	}
+
+	// Load client certificate and key (optional, for mutual TLS). A pair is
+	// inherited only as a whole.
+	clientCertFile := os.Getenv(prefix + "CLIENT_CERT")
+	clientKeyFile := os.Getenv(prefix + "CLIENT_KEY")
+
 	if clientCertFile != "" && clientKeyFile != "" {
 		config.ClientCertFile = clientCertFile
 		config.ClientKeyFile = clientKeyFile
 	} else if clientCertFile != "" || clientKeyFile != "" {
-		return nil, fmt.Errorf("both BACKEND_TLS_CLIENT_CERT and BACKEND_TLS_CLIENT_KEY must be set together")
+		return nil, fmt.Errorf("both %sCLIENT_CERT and %sCLIENT_KEY must be set together", prefix, prefix)
+	} else {
+		config.ClientCertFile = defaults.ClientCertFile
+		config.ClientKeyFile = defaults.ClientKeyFile
 	}
 
 	// Build TLS config
 	tlsConfig, err := buildBackendTLSConfig(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build backend TLS config: %w", err)
+		return nil, fmt.Errorf("failed to build backend TLS config for %s*: %w", prefix, err)
 	}
 	config.TLS = tlsConfig
 
@@ -242,13 +677,27 @@ func buildBackendTLSConfig(config *BackendTLSConfig) (*tls.Config, error) {
 			}
 			tlsConf.RootCAs = caCertPool
 		}
-		
-		// For verify-ca, we skip hostname verification
+
+		// verify-ca skips the hostname check, which crypto/tls can only do by
+		// skipping verification entirely; the chain is verified in
+		// VerifyConnection instead. For verify-full crypto/tls verifies both.
+		verifier := &backendVerifier{
+			roots:       tlsConf.RootCAs,
+			verifyChain: config.Mode == "verify-ca",
+			ocsp:        config.OCSP,
+		}
 		if config.Mode == "verify-ca" {
 			tlsConf.InsecureSkipVerify = true
-			// But we still verify the certificate chain in the connection
 		}
-		// For verify-full, hostname verification is enabled by default
+
+		if config.CRLFile != "" {
+			crls, err := newCRLSet(config.CRLFile)
+			if err != nil {
+				return nil, err
+			}
+			verifier.crls = crls
+		}
+		tlsConf.VerifyConnection = verifier.verifyConnection
 	}
 
 	// Load client certificate for mutual TLS
@@ -2,31 +2,213 @@ package main
 
 import (
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
-	"time"
+
+	"github.com/sausheong/pprox/internal/credfile"
 )
 
 // CredentialProvider is an interface for different credential sources
 type CredentialProvider interface {
-	GetCredentials(ctx context.Context) (map[string]string, error)
+	GetCredentials(ctx context.Context) (map[string]*UserEntry, error)
 	SupportsReload() bool
 }
 
+// CommittingProvider is implemented by providers that act on loaded
+// credentials only once the manager has validated and applied them
+type CommittingProvider interface {
+	CommitCredentials()
+}
+
+// ErrCredentialsNotModified is returned by providers that can tell their
+// credentials haven't changed since the last load
+var ErrCredentialsNotModified = errors.New("credentials not modified")
+
+// UserEntry is a user as returned by a credential provider
+type UserEntry struct {
+	Password   string // Plaintext password or SCRAM-SHA-256 verifier
+	Attributes UserAttributes
+}
+
+// UserAttributes are per-user privileges enforced by the proxy
+type UserAttributes struct {
+	ReadOnly         bool     `json:"read_only,omitempty"`         // Reject writes with SQLSTATE 25006
+	AllowedDatabases []string `json:"allowed_databases,omitempty"` // Databases the user may connect to, empty for all
+	MaxConnections   int      `json:"max_connections,omitempty"`   // Concurrent sessions, 0 for unlimited
+	DefaultRouting   string   `json:"default_routing,omitempty"`   // Where reads go: reader (default) or writer
+
+	// Credentials used on the user's behalf towards the backends, nil to use the DSN's
+	Backend *BackendCredentials `json:"backend,omitempty"`
+}
+
+// BackendLogin is a login on a backend. Empty fields keep the DSN's values.
+type BackendLogin struct {
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	Role     string `json:"role,omitempty"` // SET ROLE after connecting, instead of or on top of a login
+}
+
+// BackendCredentials maps a proxy user to backend logins. Backends are named
+// reader, writer0, writer1, ... (indexes into PG_WRITERS_CSV) and notify.
+type BackendCredentials struct {
+	BackendLogin                         // Default for all backends
+	Backends     map[string]BackendLogin `json:"backends,omitempty"` // Per-backend overrides
+}
+
+// ForBackend returns the login for the named backend
+func (c *BackendCredentials) ForBackend(name string) BackendLogin {
+	login := c.BackendLogin
+	if override, ok := c.Backends[name]; ok {
+		if override.User != "" {
+			// A different user doesn't inherit the default password
+			login.User = override.User
+			login.Password = override.Password
+		} else if override.Password != "" {
+			login.Password = override.Password
+		}
+		if override.Role != "" {
+			login.Role = override.Role
+		}
+	}
+	return login
+}
+
+// SetsRole reports whether the backend sessions switch to a role on any backend
+func (c *BackendCredentials) SetsRole() bool {
+	if c.Role != "" {
+		return true
+	}
+	for _, login := range c.Backends {
+		if login.Role != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Default routing targets
+const (
+	RoutingReader = "reader"
+	RoutingWriter = "writer"
+)
+
+// validate checks the attribute values
+func (a *UserAttributes) validate() error {
+	switch a.DefaultRouting {
+	case "", RoutingReader, RoutingWriter:
+	default:
+		return fmt.Errorf("invalid default routing: %s (must be: reader, writer)", a.DefaultRouting)
+	}
+	if a.MaxConnections < 0 {
+		return fmt.Errorf("invalid max connections: %d", a.MaxConnections)
+	}
+	return nil
+}
+
+// AllowsDatabase reports whether the user may connect to the database
+func (a *UserAttributes) AllowsDatabase(database string) bool {
+	if len(a.AllowedDatabases) == 0 {
+		return true
+	}
+	for _, allowed := range a.AllowedDatabases {
+		if allowed == database {
+			return true
+		}
+	}
+	return false
+}
+
+// setBackendLogin sets a field of the default or a per-backend login
+func (a *UserAttributes) setBackendLogin(backend, key, value string) {
+	if a.Backend == nil {
+		a.Backend = &BackendCredentials{}
+	}
+
+	login := &a.Backend.BackendLogin
+	var override BackendLogin
+	if backend != "" {
+		override = a.Backend.Backends[backend]
+		login = &override
+	}
+
+	switch key {
+	case "backend_user":
+		login.User = value
+	case "backend_password":
+		login.Password = value
+	case "role":
+		login.Role = value
+	}
+
+	if backend != "" {
+		if a.Backend.Backends == nil {
+			a.Backend.Backends = make(map[string]BackendLogin)
+		}
+		a.Backend.Backends[backend] = override
+	}
+}
+
+// parseUserEntry parses the password and attributes of a PG_USERS or K8s
+// secret entry. Attributes follow the password, separated by semicolons:
+//
+//	password;read_only;databases=app|reports;max_connections=5;routing=writer
+//
+// Backend credentials use backend_user, backend_password and role, with an
+// optional @backend suffix for a single backend, e.g. role@reader=analyst.
+func parseUserEntry(value string) (*UserEntry, error) {
+	parts := strings.Split(value, ";")
+	entry := &UserEntry{Password: strings.TrimSpace(parts[0])}
+
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, val, _ := strings.Cut(part, "=")
+		key, backend, _ := strings.Cut(key, "@")
+		switch key {
+		case "read_only":
+			entry.Attributes.ReadOnly = true
+		case "databases":
+			for _, db := range strings.Split(val, "|") {
+				if db = strings.TrimSpace(db); db != "" {
+					entry.Attributes.AllowedDatabases = append(entry.Attributes.AllowedDatabases, db)
+				}
+			}
+		case "max_connections":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid max_connections: %s", val)
+			}
+			entry.Attributes.MaxConnections = n
+		case "routing":
+			entry.Attributes.DefaultRouting = val
+		case "backend_user", "backend_password", "role":
+			entry.Attributes.setBackendLogin(backend, key, val)
+		default:
+			return nil, fmt.Errorf("unknown user attribute: %s", key)
+		}
+	}
+
+	return entry, nil
+}
+
 // CredentialManager manages user credentials from various sources
 type CredentialManager struct {
 	provider      CredentialProvider
 	authConfig    *AuthConfig
 	mu            sync.RWMutex
 	reloadEnabled bool
+	watchEnabled  bool
 	stopReload    chan struct{}
+	onReload      []func(previous, current *AuthConfig)
 }
 
 // NewCredentialManager creates a new credential manager
@@ -35,66 +217,75 @@ func NewCredentialManager(provider CredentialProvider) *CredentialManager {
 		provider:      provider,
 		authConfig:    NewAuthConfig(),
 		reloadEnabled: provider.SupportsReload(),
+		watchEnabled:  true,
 		stopReload:    make(chan struct{}),
 	}
 }
 
-// LoadCredentials loads credentials from the provider
+// LoadCredentials loads credentials from the provider. It returns
+// ErrCredentialsNotModified, keeping the current credentials, if the
+// provider reports that nothing changed since the last load.
 func (cm *CredentialManager) LoadCredentials(ctx context.Context) error {
 	credentials, err := cm.provider.GetCredentials(ctx)
+	if errors.Is(err, ErrCredentialsNotModified) {
+		return err
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get credentials: %w", err)
 	}
 
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
+	// With no users the proxy runs in trust mode, so a reload that comes back
+	// empty (a truncated file, an emptied secret) must not replace real users
+	if len(credentials) == 0 {
+		if current := cm.GetAuthConfig(); current != nil && len(current.Users) > 0 {
+			return fmt.Errorf("refusing to replace %d users with an empty credential set", len(current.Users))
+		}
+	}
 
 	// Create new auth config
 	newAuthConfig := NewAuthConfig()
-	for username, password := range credentials {
-		if err := newAuthConfig.AddUser(username, password); err != nil {
+	for username, entry := range credentials {
+		if err := entry.Attributes.validate(); err != nil {
+			return fmt.Errorf("invalid attributes for user %s: %w", username, err)
+		}
+		if err := newAuthConfig.AddUser(username, entry.Password); err != nil {
 			return fmt.Errorf("failed to add user %s: %w", username, err)
 		}
+		newAuthConfig.Users[username].Attributes = entry.Attributes
 	}
 
+	cm.mu.Lock()
+	oldAuthConfig := cm.authConfig
 	cm.authConfig = newAuthConfig
+	callbacks := cm.onReload
+	cm.mu.Unlock()
+
+	if cp, ok := cm.provider.(CommittingProvider); ok {
+		cp.CommitCredentials()
+	}
+
+	for _, fn := range callbacks {
+		fn(oldAuthConfig, newAuthConfig)
+	}
+
 	return nil
 }
 
-// GetAuthConfig returns the current auth configuration
+// OnReload registers a callback that runs after new credentials are swapped in
+func (cm *CredentialManager) OnReload(fn func(previous, current *AuthConfig)) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.onReload = append(cm.onReload, fn)
+}
+
+// GetAuthConfig returns the current auth configuration. The returned
+// AuthConfig is never modified, so it can be used without holding a lock.
 func (cm *CredentialManager) GetAuthConfig() *AuthConfig {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
 	return cm.authConfig
 }
 
-// StartAutoReload starts automatic credential reloading
-func (cm *CredentialManager) StartAutoReload(ctx context.Context, interval time.Duration) {
-	if !cm.reloadEnabled {
-		return
-	}
-
-	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				if err := cm.LoadCredentials(ctx); err != nil {
-					fmt.Printf("Failed to reload credentials: %v\n", err)
-				} else {
-					fmt.Println("Credentials reloaded successfully")
-				}
-			case <-cm.stopReload:
-				return
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
-}
-
 // StopAutoReload stops automatic credential reloading
 func (cm *CredentialManager) StopAutoReload() {
 	close(cm.stopReload)
@@ -111,8 +302,8 @@ func NewEnvCredentialProvider() *EnvCredentialProvider {
 	return &EnvCredentialProvider{}
 }
 
-func (p *EnvCredentialProvider) GetCredentials(ctx context.Context) (map[string]string, error) {
-	credentials := make(map[string]string)
+func (p *EnvCredentialProvider) GetCredentials(ctx context.Context) (map[string]*UserEntry, error) {
+	credentials := make(map[string]*UserEntry)
 
 	usersEnv := os.Getenv("PG_USERS")
 	if usersEnv == "" {
@@ -125,7 +316,11 @@ func (p *EnvCredentialProvider) GetCredentials(ctx context.Context) (map[string]
 		if len(parts) != 2 {
 			return nil, fmt.Errorf("invalid user format: %s", pair)
 		}
-		credentials[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		entry, err := parseUserEntry(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry for user %s: %w", parts[0], err)
+		}
+		credentials[strings.TrimSpace(parts[0])] = entry
 	}
 
 	return credentials, nil
@@ -141,47 +336,107 @@ func (p *EnvCredentialProvider) SupportsReload() bool {
 
 // FileCredentialProvider loads credentials from a JSON file
 type FileCredentialProvider struct {
-	filePath       string
-	encryptionKey  []byte
-	lastModified   time.Time
+	filePath      string
+	encryptionKey string            // Passphrase; the file's header selects the KDF
+	lastDigest    [sha256.Size]byte // Digest of the last applied file
+	nextDigest    [sha256.Size]byte // Digest of the last loaded file, recorded once it is applied
+	warnedLegacy  bool
 }
 
-// CredentialFile represents the JSON structure for credentials
+// CredentialFile represents the JSON structure for credentials.
+// Password may be a plaintext password or a SCRAM-SHA-256 verifier.
 type CredentialFile struct {
 	Users []struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		UserAttributes
 	} `json:"users"`
 }
 
-func NewFileCredentialProvider(filePath string, encryptionKey string) *FileCredentialProvider {
-	var key []byte
-	if encryptionKey != "" {
-		// Derive 32-byte key from provided key
-		key = make([]byte, 32)
-		copy(key, []byte(encryptionKey))
+// parseUserValue parses the value of a user in a JSON secret: a password
+// string (with optional ";attributes" as in PG_USERS) or an object with a
+// password field and the fields of UserAttributes
+func parseUserValue(raw json.RawMessage) (*UserEntry, error) {
+	var password string
+	if err := json.Unmarshal(raw, &password); err == nil {
+		return parseUserEntry(password)
 	}
 
+	var user struct {
+		Password string `json:"password"`
+		UserAttributes
+	}
+	if err := json.Unmarshal(raw, &user); err != nil {
+		return nil, fmt.Errorf("expected a password or an object: %w", err)
+	}
+	return &UserEntry{Password: user.Password, Attributes: user.UserAttributes}, nil
+}
+
+// parseSecretDocument parses a JSON secret holding either a credential file
+// document or an object mapping usernames to user values
+func parseSecretDocument(data []byte) (map[string]*UserEntry, error) {
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	if users, ok := document["users"]; ok && strings.HasPrefix(strings.TrimSpace(string(users)), "[") {
+		var credFile CredentialFile
+		if err := json.Unmarshal(data, &credFile); err != nil {
+			return nil, err
+		}
+		return credFile.entries(), nil
+	}
+
+	credentials := make(map[string]*UserEntry)
+	for username, raw := range document {
+		entry, err := parseUserValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry for user %s: %w", username, err)
+		}
+		credentials[username] = entry
+	}
+	return credentials, nil
+}
+
+// entries returns the users of a credential file
+func (f *CredentialFile) entries() map[string]*UserEntry {
+	credentials := make(map[string]*UserEntry)
+	for _, user := range f.Users {
+		credentials[user.Username] = &UserEntry{Password: user.Password, Attributes: user.UserAttributes}
+	}
+	return credentials
+}
+
+func NewFileCredentialProvider(filePath string, encryptionKey string) *FileCredentialProvider {
 	return &FileCredentialProvider{
 		filePath:      filePath,
-		encryptionKey: key,
+		encryptionKey: encryptionKey,
 	}
 }
 
-func (p *FileCredentialProvider) GetCredentials(ctx context.Context) (map[string]string, error) {
+func (p *FileCredentialProvider) GetCredentials(ctx context.Context) (map[string]*UserEntry, error) {
+	// A failed load must not commit the digest of an earlier one
+	p.nextDigest = p.lastDigest
+
 	data, err := os.ReadFile(p.filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read credential file: %w", err)
 	}
 
-	// Update last modified time
-	if info, err := os.Stat(p.filePath); err == nil {
-		p.lastModified = info.ModTime()
+	// Watch events and polls fire for touched or re-mounted files too
+	digest := sha256.Sum256(data)
+	if digest == p.lastDigest {
+		return nil, ErrCredentialsNotModified
 	}
 
 	// Decrypt if encryption key is provided
-	if p.encryptionKey != nil {
-		data, err = p.decrypt(data)
+	if p.encryptionKey != "" {
+		if credfile.IsLegacy(data) && !p.warnedLegacy {
+			log.Printf("Credential file %s uses the legacy encryption format; convert it with: pprox-encrypt-credentials migrate -input %s -key <passphrase>", p.filePath, p.filePath)
+			p.warnedLegacy = true
+		}
+		data, err = credfile.Decrypt(data, p.encryptionKey)
 		if err != nil {
 			return nil, fmt.Errorf("failed to decrypt credentials: %w", err)
 		}
@@ -192,41 +447,24 @@ func (p *FileCredentialProvider) GetCredentials(ctx context.Context) (map[string
 		return nil, fmt.Errorf("failed to parse credential file: %w", err)
 	}
 
-	credentials := make(map[string]string)
-	for _, user := range credFile.Users {
-		credentials[user.Username] = user.Password
-	}
-
-	return credentials, nil
+	p.nextDigest = digest
+	return credFile.entries(), nil
 }
 
-func (p *FileCredentialProvider) SupportsReload() bool {
-	return true
+// CommitCredentials records the digest of the last load once the manager has
+// validated and applied its credentials, so a rejected file is loaded again
+// instead of being skipped as unchanged
+func (p *FileCredentialProvider) CommitCredentials() {
+	p.lastDigest = p.nextDigest
 }
 
-func (p *FileCredentialProvider) decrypt(ciphertext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(p.encryptionKey)
-	if err != nil {
-		return nil, err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-
-	nonceSize := gcm.NonceSize()
-	if len(ciphertext) < nonceSize {
-		return nil, fmt.Errorf("ciphertext too short")
-	}
-
-	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
-		return nil, err
-	}
+// WatchPaths returns the credential file
+func (p *FileCredentialProvider) WatchPaths() []string {
+	return []string{p.filePath}
+}
 
-	return plaintext, nil
+func (p *FileCredentialProvider) SupportsReload() bool {
+	return true
 }
 
 // EncryptCredentialFile encrypts a credential file (utility function)
@@ -236,27 +474,11 @@ func EncryptCredentialFile(inputPath, outputPath, encryptionKey string) error {
 		return fmt.Errorf("failed to read input file: %w", err)
 	}
 
-	// Derive 32-byte key
-	key := make([]byte, 32)
-	copy(key, []byte(encryptionKey))
-
-	block, err := aes.NewCipher(key)
+	ciphertext, err := credfile.Encrypt(data, encryptionKey, credfile.DefaultArgon2id)
 	if err != nil {
 		return err
 	}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return err
-	}
-
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return err
-	}
-
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
-	
 	if err := os.WriteFile(outputPath, ciphertext, 0600); err != nil {
 		return fmt.Errorf("failed to write encrypted file: %w", err)
 	}
@@ -264,75 +486,15 @@ func EncryptCredentialFile(inputPath, outputPath, encryptionKey string) error {
 	return nil
 }
 
-// ========================================================================
-// HashiCorp Vault Provider
-// ========================================================================
-
-// VaultCredentialProvider loads credentials from HashiCorp Vault
-type VaultCredentialProvider struct {
-	address    string
-	token      string
-	secretPath string
-}
-
-func NewVaultCredentialProvider(address, token, secretPath string) *VaultCredentialProvider {
-	return &VaultCredentialProvider{
-		address:    address,
-		token:      token,
-		secretPath: secretPath,
-	}
-}
-
-func (p *VaultCredentialProvider) GetCredentials(ctx context.Context) (map[string]string, error) {
-	// This is a simplified implementation
-	// In production, use the official Vault client library
-	// github.com/hashicorp/vault/api
-	
-	// For now, return an error indicating this needs the Vault library
-	return nil, fmt.Errorf("Vault provider requires hashicorp/vault/api library - see CREDENTIALS.md for setup")
-}
-
-func (p *VaultCredentialProvider) SupportsReload() bool {
-	return true
-}
-
-// ========================================================================
-// AWS Secrets Manager Provider
-// ========================================================================
-
-// AWSSecretsProvider loads credentials from AWS Secrets Manager
-type AWSSecretsProvider struct {
-	secretName string
-	region     string
-}
-
-func NewAWSSecretsProvider(secretName, region string) *AWSSecretsProvider {
-	return &AWSSecretsProvider{
-		secretName: secretName,
-		region:     region,
-	}
-}
-
-func (p *AWSSecretsProvider) GetCredentials(ctx context.Context) (map[string]string, error) {
-	// This is a simplified implementation
-	// In production, use the official AWS SDK
-	// github.com/aws/aws-sdk-go-v2/service/secretsmanager
-	
-	// For now, return an error indicating this needs the AWS SDK
-	return nil, fmt.Errorf("AWS Secrets Manager provider requires aws-sdk-go-v2 - see CREDENTIALS.md for setup")
-}
-
-func (p *AWSSecretsProvider) SupportsReload() bool {
-	return true
-}
-
 // ========================================================================
 // Kubernetes Secret Provider
 // ========================================================================
 
 // K8sSecretProvider loads credentials from Kubernetes secrets
 type K8sSecretProvider struct {
-	secretPath string // Path where secret is mounted (e.g., /var/run/secrets/pprox)
+	secretPath string            // Path where secret is mounted (e.g., /var/run/secrets/pprox)
+	lastDigest [sha256.Size]byte // Digest of the last applied users file
+	nextDigest [sha256.Size]byte // Digest of the last loaded users file, recorded once it is applied
 }
 
 func NewK8sSecretProvider(secretPath string) *K8sSecretProvider {
@@ -341,19 +503,26 @@ func NewK8sSecretProvider(secretPath string) *K8sSecretProvider {
 	}
 }
 
-func (p *K8sSecretProvider) GetCredentials(ctx context.Context) (map[string]string, error) {
+func (p *K8sSecretProvider) GetCredentials(ctx context.Context) (map[string]*UserEntry, error) {
 	// Read credentials from mounted secret files
 	// Kubernetes mounts secrets as files in the pod
 	
+	// A failed load must not commit the digest of an earlier one
+	p.nextDigest = p.lastDigest
+
 	usersFile := p.secretPath + "/users"
 	data, err := os.ReadFile(usersFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read K8s secret: %w", err)
 	}
+	digest := sha256.Sum256(data)
+	if digest == p.lastDigest {
+		return nil, ErrCredentialsNotModified
+	}
 
-	credentials := make(map[string]string)
-	
-	// Parse format: username:password (one per line)
+	credentials := make(map[string]*UserEntry)
+
+	// Parse format: username:password[;attributes] (one per line)
 	lines := strings.Split(string(data), "\n")
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -365,42 +534,31 @@ func (p *K8sSecretProvider) GetCredentials(ctx context.Context) (map[string]stri
 		if len(parts) != 2 {
 			return nil, fmt.Errorf("invalid credential format in K8s secret: %s", line)
 		}
-		
-		credentials[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+
+		entry, err := parseUserEntry(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry for user %s in K8s secret: %w", parts[0], err)
+		}
+		credentials[strings.TrimSpace(parts[0])] = entry
 	}
 
+	p.nextDigest = digest
 	return credentials, nil
 }
 
-func (p *K8sSecretProvider) SupportsReload() bool {
-	return true // K8s can update mounted secrets
-}
-
-// ========================================================================
-// HTTP API Provider (for custom credential services)
-// ========================================================================
-
-// HTTPCredentialProvider loads credentials from an HTTP API
-type HTTPCredentialProvider struct {
-	endpoint string
-	apiKey   string
+// CommitCredentials records the digest of the last load once the manager has
+// validated and applied its credentials
+func (p *K8sSecretProvider) CommitCredentials() {
+	p.lastDigest = p.nextDigest
 }
 
-func NewHTTPCredentialProvider(endpoint, apiKey string) *HTTPCredentialProvider {
-	return &HTTPCredentialProvider{
-		endpoint: endpoint,
-		apiKey:   apiKey,
-	}
-}
-
-func (p *HTTPCredentialProvider) GetCredentials(ctx context.Context) (map[string]string, error) {
-	// This would make an HTTP request to your credential service
-	// For now, return an error indicating this needs implementation
-	return nil, fmt.Errorf("HTTP provider requires net/http implementation - see CREDENTIALS.md for setup")
+func (p *K8sSecretProvider) SupportsReload() bool {
+	return true // K8s can update mounted secrets
 }
 
-func (p *HTTPCredentialProvider) SupportsReload() bool {
-	return true
+// WatchPaths returns the users file of the mounted secret
+func (p *K8sSecretProvider) WatchPaths() []string {
+	return []string{p.secretPath + "/users"}
 }
 
 // ========================================================================
@@ -409,9 +567,24 @@ func (p *HTTPCredentialProvider) SupportsReload() bool {
 
 // CreateCredentialProvider creates the appropriate provider based on configuration
 func CreateCredentialProvider() (CredentialProvider, error) {
-	// Check for credential source configuration
+	// Check for credential source configuration; a comma-separated list
+	// combines several sources
 	source := os.Getenv("CREDENTIAL_SOURCE")
-	
+	if strings.Contains(source, ",") {
+		var names []string
+		for _, name := range strings.Split(source, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		return loadCompositeProvider(names)
+	}
+
+	return createSourceProvider(source)
+}
+
+// createSourceProvider creates the provider of a single credential source
+func createSourceProvider(source string) (CredentialProvider, error) {
 	switch source {
 	case "file":
 		filePath := os.Getenv("CREDENTIAL_FILE")
@@ -422,22 +595,19 @@ func CreateCredentialProvider() (CredentialProvider, error) {
 		return NewFileCredentialProvider(filePath, encryptionKey), nil
 		
 	case "vault":
-		address := os.Getenv("VAULT_ADDR")
-		token := os.Getenv("VAULT_TOKEN")
-		path := os.Getenv("VAULT_SECRET_PATH")
-		if address == "" || token == "" || path == "" {
-			return nil, fmt.Errorf("VAULT_ADDR, VAULT_TOKEN, and VAULT_SECRET_PATH must be set")
+		config, err := loadVaultConfig()
+		if err != nil {
+			return nil, err
 		}
-		return NewVaultCredentialProvider(address, token, path), nil
-		
+		return NewVaultCredentialProvider(config), nil
+
 	case "aws":
-		secretName := os.Getenv("AWS_SECRET_NAME")
-		region := os.Getenv("AWS_REGION")
-		if secretName == "" || region == "" {
-			return nil, fmt.Errorf("AWS_SECRET_NAME and AWS_REGION must be set")
+		config, err := loadAWSSecretsConfig()
+		if err != nil {
+			return nil, err
 		}
-		return NewAWSSecretsProvider(secretName, region), nil
-		
+		return NewAWSSecretsProvider(config), nil
+
 	case "k8s":
 		secretPath := os.Getenv("K8S_SECRET_PATH")
 		if secretPath == "" {
@@ -446,13 +616,12 @@ func CreateCredentialProvider() (CredentialProvider, error) {
 		return NewK8sSecretProvider(secretPath), nil
 		
 	case "http":
-		endpoint := os.Getenv("CREDENTIAL_API_ENDPOINT")
-		apiKey := os.Getenv("CREDENTIAL_API_KEY")
-		if endpoint == "" {
-			return nil, fmt.Errorf("CREDENTIAL_API_ENDPOINT must be set")
+		config, err := loadHTTPProviderConfig()
+		if err != nil {
+			return nil, err
 		}
-		return NewHTTPCredentialProvider(endpoint, apiKey), nil
-		
+		return NewHTTPCredentialProvider(config), nil
+
 	case "env", "":
 		// Default to environment variables
 		return NewEnvCredentialProvider(), nil
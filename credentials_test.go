@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileProviderReloadsRejectedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(path, []byte(`{"users":[{"username":"alice","password":"secret","max_connections":-1}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cm := NewCredentialManager(NewFileCredentialProvider(path, ""))
+	ctx := context.Background()
+
+	// A file that fails validation is not skipped as unchanged later
+	for i := 0; i < 2; i++ {
+		if err := cm.LoadCredentials(ctx); err == nil || errors.Is(err, ErrCredentialsNotModified) {
+			t.Fatalf("LoadCredentials() %d of an invalid file error = %v, want a validation error", i+1, err)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(`{"users":[{"username":"alice","password":"secret"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.LoadCredentials(ctx); err != nil {
+		t.Fatalf("LoadCredentials() of a valid file error = %v", err)
+	}
+	if err := cm.LoadCredentials(ctx); !errors.Is(err, ErrCredentialsNotModified) {
+		t.Errorf("LoadCredentials() of an applied file error = %v, want ErrCredentialsNotModified", err)
+	}
+}
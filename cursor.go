@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Cursor is a server-side cursor pinned to a backend connection for its lifetime
+type Cursor struct {
+	name string
+	conn *pgx.Conn
+	tx   pgx.Tx // Transaction holding the cursor open, nil for WITH HOLD cursors
+}
+
+// close releases the cursor's transaction and backend connection
+func (c *Cursor) close(ctx context.Context) {
+	if c.tx != nil {
+		// Ending the transaction closes the cursor
+		if err := c.tx.Commit(ctx); err != nil {
+			c.tx.Rollback(ctx)
+		}
+	}
+	c.conn.Close(ctx)
+}
+
+// cursorStatement is a parsed DECLARE, FETCH, MOVE or CLOSE statement
+type cursorStatement struct {
+	command  string // DECLARE, FETCH, MOVE or CLOSE
+	name     string // Cursor name, empty for CLOSE ALL
+	withHold bool   // DECLARE ... WITH HOLD
+	query    string // Query of a DECLARE
+}
+
+// sqlWord is a token of a SQL statement
+type sqlWord struct {
+	text   string // Folded identifier or keyword
+	quoted bool   // Whether the word was a quoted identifier
+	end    int    // Offset just past the word in the statement
+}
+
+// splitSQLWords splits a statement into identifiers and keywords. Unquoted
+// words are folded to lower case; quoted identifiers keep their case.
+func splitSQLWords(sql string) ([]sqlWord, error) {
+	var words []sqlWord
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ',' || c == ';':
+			i++
+		case c == '"':
+			var name strings.Builder
+			j := i + 1
+			for {
+				if j >= len(sql) {
+					return nil, fmt.Errorf("unterminated quoted identifier")
+				}
+				if sql[j] == '"' {
+					if j+1 < len(sql) && sql[j+1] == '"' {
+						name.WriteByte('"')
+						j += 2
+						continue
+					}
+					break
+				}
+				name.WriteByte(sql[j])
+				j++
+			}
+			words = append(words, sqlWord{text: name.String(), quoted: true, end: j + 1})
+			i = j + 1
+		default:
+			j := i
+			for j < len(sql) && !strings.ContainsRune(" \t\r\n,;\"", rune(sql[j])) {
+				j++
+			}
+			words = append(words, sqlWord{text: strings.ToLower(sql[i:j]), end: j})
+			i = j
+		}
+	}
+
+	return words, nil
+}
+
+// isKeyword reports whether a word is the given unquoted keyword
+func (w sqlWord) isKeyword(keyword string) bool {
+	return !w.quoted && w.text == keyword
+}
+
+// parseCursorStatement parses a cursor statement. FETCH, MOVE and CLOSE end
+// with the cursor name; DECLARE names the cursor right after the keyword.
+func parseCursorStatement(sql string) (*cursorStatement, error) {
+	sql = skipLeadingComments(sql)
+	words, err := splitSQLWords(sql)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) < 2 {
+		return nil, fmt.Errorf("cursor name not provided")
+	}
+
+	stmt := &cursorStatement{command: strings.ToUpper(words[0].text)}
+
+	switch stmt.command {
+	case "DECLARE":
+		stmt.name = words[1].text
+		for i := 2; i < len(words); i++ {
+			if words[i].isKeyword("with") && i+1 < len(words) && words[i+1].isKeyword("hold") {
+				stmt.withHold = true
+			}
+			if words[i].isKeyword("for") {
+				stmt.query = strings.TrimSpace(sql[words[i].end:])
+				break
+			}
+		}
+		if stmt.query == "" {
+			return nil, fmt.Errorf("DECLARE requires a query")
+		}
+	case "CLOSE":
+		if len(words) == 2 && words[1].isKeyword("all") {
+			return stmt, nil
+		}
+		stmt.name = words[len(words)-1].text
+	case "FETCH", "MOVE":
+		stmt.name = words[len(words)-1].text
+	default:
+		return nil, fmt.Errorf("not a cursor statement: %s", words[0].text)
+	}
+
+	return stmt, nil
+}
+
+// executeCursor runs a cursor statement on the backend connection pinned to the cursor
+func (h *ClientHandler) executeCursor(ctx context.Context, sql string, params [][]byte, paramOIDs []uint32, resultFormats []int16) (*WriteResult, error) {
+	stmt, err := parseCursorStatement(sql)
+	if err != nil {
+		return nil, &pgconn.PgError{Code: "42601", Message: fmt.Sprintf("invalid cursor statement: %v", err)}
+	}
+
+	if stmt.command == "DECLARE" {
+		return h.declareCursor(ctx, stmt, sql, params, paramOIDs)
+	}
+
+	if stmt.command == "CLOSE" && stmt.name == "" {
+		h.closeAllCursors(ctx)
+		return &WriteResult{CommandTag: pgconn.NewCommandTag("CLOSE CURSOR ALL")}, nil
+	}
+
+	cursor, exists := h.cursors[stmt.name]
+	if !exists {
+		return nil, &pgconn.PgError{Code: "34000", Message: fmt.Sprintf("cursor \"%s\" does not exist", stmt.name)}
+	}
+
+	result, err := execOnConn(ctx, cursor.conn, sql, params, paramOIDs, resultFormats)
+	if err != nil {
+		// An error aborts the transaction holding the cursor, so it can't be used again
+		if cursor.tx != nil || stmt.command == "CLOSE" {
+			delete(h.cursors, stmt.name)
+			cursor.close(ctx)
+		}
+		return nil, err
+	}
+
+	if stmt.command == "CLOSE" {
+		delete(h.cursors, stmt.name)
+		cursor.close(ctx)
+	}
+
+	return result, nil
+}
+
+// declareCursor opens a pinned backend connection and declares the cursor on it.
+// Cursors over read queries use the reader, everything else the primary writer.
+func (h *ClientHandler) declareCursor(ctx context.Context, stmt *cursorStatement, sql string, params [][]byte, paramOIDs []uint32) (*WriteResult, error) {
+	if _, exists := h.cursors[stmt.name]; exists {
+		return nil, &pgconn.PgError{Code: "42P03", Message: fmt.Sprintf("cursor \"%s\" already exists", stmt.name)}
+	}
+
+	name, dsn := writerBackendName(h.router.config.PrimaryWriter), h.router.config.WriterDSNs[h.router.config.PrimaryWriter]
+	if ClassifyQuery(stmt.query) == QueryTypeRead {
+		name, dsn = h.readerBackend()
+	}
+
+	conn, err := h.router.connectToBackend(ctx, name, dsn, h.connectOptions())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to backend: %w", err)
+	}
+
+	cursor := &Cursor{name: stmt.name, conn: conn}
+
+	// Cursors without WITH HOLD only live as long as their transaction
+	if !stmt.withHold {
+		cursor.tx, err = conn.Begin(ctx)
+		if err != nil {
+			conn.Close(ctx)
+			return nil, fmt.Errorf("failed to begin cursor transaction: %w", err)
+		}
+	}
+
+	result, err := execOnConn(ctx, conn, sql, params, paramOIDs, nil)
+	if err != nil {
+		if cursor.tx != nil {
+			cursor.tx.Rollback(ctx)
+		}
+		conn.Close(ctx)
+		return nil, err
+	}
+
+	h.cursors[stmt.name] = cursor
+	log.Printf("Declared cursor %q for user %s", stmt.name, h.username)
+
+	return result, nil
+}
+
+// describeCursor returns the columns a FETCH statement produces, or nil if
+// the statement doesn't return rows or its cursor isn't open
+func (h *ClientHandler) describeCursor(sql string) []pgconn.FieldDescription {
+	stmt, err := parseCursorStatement(sql)
+	if err != nil || stmt.command != "FETCH" {
+		return nil
+	}
+
+	cursor, exists := h.cursors[stmt.name]
+	if !exists {
+		return nil
+	}
+
+	ctx, cancel := h.queryContext()
+	defer cancel()
+
+	sd, err := cursor.conn.PgConn().Prepare(ctx, "", sql, nil)
+	if err != nil {
+		return nil
+	}
+	return sd.Fields
+}
+
+// closeAllCursors closes every cursor of the session
+func (h *ClientHandler) closeAllCursors(ctx context.Context) {
+	for name, cursor := range h.cursors {
+		cursor.close(ctx)
+		delete(h.cursors, name)
+	}
+}
+
+// execOnConn executes a single statement on a connection and collects its raw result
+func execOnConn(ctx context.Context, conn *pgx.Conn, sql string, params [][]byte, paramOIDs []uint32, resultFormats []int16) (*WriteResult, error) {
+	rr := conn.PgConn().ExecParams(ctx, sql, params, paramOIDs, nil, resultFormats)
+
+	result := &WriteResult{}
+	for rr.NextRow() {
+		values := rr.Values()
+		row := make([][]byte, len(values))
+		for i, v := range values {
+			if v != nil {
+				row[i] = append([]byte{}, v...)
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	result.Fields = append(result.Fields, rr.FieldDescriptions()...)
+
+	tag, err := rr.Close()
+	if err != nil {
+		return nil, err
+	}
+	result.CommandTag = tag
+
+	return result, nil
+}
@@ -3,12 +3,17 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 // PreparedStatement stores information about a prepared statement
@@ -29,37 +34,60 @@ type Portal struct {
 
 // ClientHandler handles a single client connection
 type ClientHandler struct {
-	conn               net.Conn
-	backend            *pgproto3.Backend
-	router             *Router
-	preparedStmts      map[string]*PreparedStatement
-	portals            map[string]*Portal
-	tlsState           *tls.ConnectionState
-	authenticated      bool
-	username           string
+	conn           net.Conn
+	backend        *pgproto3.Backend
+	router         *Router
+	preparedStmts  map[string]*PreparedStatement
+	portals        map[string]*Portal
+	tlsState       *tls.ConnectionState
+	channelBinding []byte // tls-server-end-point data for SCRAM-SHA-256-PLUS
+	authenticated  bool
+	username       string
+	database       string
+	cursors        map[string]*Cursor
+
+	// Session context, cancelled when the client disconnects
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Asynchronous notifications are only written while the client is idle
+	notifyMu             sync.Mutex
+	busy                 bool
+	pendingNotifications []*pgproto3.NotificationResponse
 }
 
 // NewClientHandler creates a new client handler
 func NewClientHandler(conn net.Conn, router *Router) *ClientHandler {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &ClientHandler{
 		conn:          conn,
 		backend:       pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn),
 		router:        router,
 		preparedStmts: make(map[string]*PreparedStatement),
 		portals:       make(map[string]*Portal),
+		cursors:       make(map[string]*Cursor),
+		ctx:           ctx,
+		cancel:        cancel,
 	}
 }
 
 // Handle processes messages from the client
 func (h *ClientHandler) Handle() {
 	defer h.conn.Close()
+	defer h.cancel()
+	defer h.router.notifications.UnsubscribeAll(h)
+	defer h.closeAllCursors(context.Background())
+	defer h.router.unregisterSession(h)
 
-	// Handle startup
+	// Handle startup; the session is registered once authenticated
 	if err := h.handleStartup(); err != nil {
 		log.Printf("Startup error: %v", err)
 		return
 	}
 
+	// Watch the client connection so a disconnect cancels running queries
+	h.watchConn()
+
 	// Main message loop
 	for {
 		msg, err := h.backend.Receive()
@@ -69,6 +97,7 @@ func (h *ClientHandler) Handle() {
 			}
 			return
 		}
+		h.setBusy()
 
 		switch msg := msg.(type) {
 		case *pgproto3.Query:
@@ -96,6 +125,52 @@ func (h *ClientHandler) Handle() {
 	}
 }
 
+// watchConn reads client input on a separate goroutine and feeds it to the
+// message loop through a pipe. A read error or EOF cancels the session context,
+// so queries abandoned by a disconnected client are cancelled on the backends.
+func (h *ClientHandler) watchConn() {
+	pr, pw := io.Pipe()
+	conn := h.conn
+
+	go func() {
+		_, err := io.Copy(pw, conn)
+		if err == nil {
+			err = io.EOF
+		}
+		pw.CloseWithError(err)
+		h.cancel()
+	}()
+
+	h.backend = pgproto3.NewBackend(pgproto3.NewChunkReader(pr), conn)
+}
+
+// terminate ends the session from another goroutine, telling the client why
+// if it is idle
+func (h *ClientHandler) terminate(message string) {
+	h.notifyMu.Lock()
+	if !h.busy {
+		h.sendFatal("57P01", message) // admin_shutdown
+	}
+	h.notifyMu.Unlock()
+
+	h.cancel()
+	h.conn.Close()
+}
+
+// queryContext derives a context for a single query from the session context,
+// applying the statement timeout configured for the user
+func (h *ClientHandler) queryContext() (context.Context, context.CancelFunc) {
+	timeout := h.router.config.StatementTimeout
+	if userTimeout, exists := h.router.config.UserStatementTimeouts[h.username]; exists {
+		timeout = userTimeout
+	}
+
+	if timeout > 0 {
+		return context.WithTimeout(h.ctx, timeout)
+	}
+	return context.WithCancel(h.ctx)
+}
+
 // handleStartup handles the PostgreSQL startup sequence
 func (h *ClientHandler) handleStartup() error {
 	startupMsg, err := h.backend.ReceiveStartupMessage()
@@ -114,15 +189,28 @@ func (h *ClientHandler) handleStartup() error {
 			}
 
 			// Upgrade to TLS
-			tlsConn := tls.Server(h.conn, h.router.config.TLSConfig.TLS)
+			tlsConf, servedCertificate := h.router.config.TLSConfig.connectionConfig()
+			tlsConn := tls.Server(h.conn, tlsConf)
 			if err := tlsConn.Handshake(); err != nil {
 				return fmt.Errorf("TLS handshake failed: %w", err)
 			}
 
-			// Store TLS state for channel binding
+			// Store TLS state and the hash of the certificate this connection
+			// was served for channel binding; it may differ from the current
+			// one after a reload
 			state := tlsConn.ConnectionState()
 			h.tlsState = &state
 
+			if cert := servedCertificate(); cert != nil {
+				leaf, err := leafCertificate(cert)
+				if err == nil {
+					h.channelBinding, err = GetTLSServerEndPoint(leaf)
+				}
+				if err != nil {
+					log.Printf("Channel binding unavailable: %v", err)
+				}
+			}
+
 			// Update connection and backend
 			h.conn = tlsConn
 			h.backend = pgproto3.NewBackend(pgproto3.NewChunkReader(tlsConn), tlsConn)
@@ -164,15 +252,12 @@ func (h *ClientHandler) handleStartup() error {
 
 		h.username = username
 
-		// Check if authentication is required
-		if len(h.router.config.AuthConfig.Users) == 0 {
-			// Trust mode - no authentication
-			h.authenticated = true
-			return h.sendAuthenticationOk()
+		h.database = msg.Parameters["database"]
+		if h.database == "" {
+			h.database = username
 		}
 
-		// Perform SCRAM-SHA-256 authentication
-		return h.performSCRAMAuth(username)
+		return h.authenticate(username, h.database)
 	default:
 		return fmt.Errorf("unexpected startup message type: %T", startupMsg)
 	}
@@ -180,12 +265,36 @@ func (h *ClientHandler) handleStartup() error {
 
 // handleQuery processes a query message
 func (h *ClientHandler) handleQuery(sql string) {
-	ctx := context.Background()
+	ctx, cancel := h.queryContext()
+	defer cancel()
 	queryType := ClassifyQuery(sql)
 
-	if queryType == QueryTypeRead {
+	if err := h.checkReadOnly(sql); err != nil {
+		h.sendQueryError(err)
+		h.sendReadyForQuery('I')
+		return
+	}
+	if err := h.checkRoleChange(sql); err != nil {
+		h.sendQueryError(err)
+		h.sendReadyForQuery('I')
+		return
+	}
+	if mixesListen(sql) {
+		h.sendQueryError(&pgconn.PgError{Code: "0A000", Message: "LISTEN and UNLISTEN cannot be combined with other statements in one query"}) // feature_not_supported
+		h.sendReadyForQuery('I')
+		return
+	}
+
+	switch queryType {
+	case QueryTypeRead:
 		h.handleReadQuery(ctx, sql)
-	} else {
+	case QueryTypeListen, QueryTypeUnlisten:
+		h.handleListenQuery(ctx, sql)
+	case QueryTypeNotify:
+		h.handleNotifyQuery(ctx, sql)
+	case QueryTypeCursor:
+		h.handleCursorQuery(ctx, sql)
+	default:
 		h.handleWriteQuery(ctx, sql)
 	}
 }
@@ -193,7 +302,8 @@ func (h *ClientHandler) handleQuery(sql string) {
 // handleReadQuery executes a read query and returns results
 func (h *ClientHandler) handleReadQuery(ctx context.Context, sql string) {
 	// Connect to reader
-	conn, err := h.router.connectToBackend(ctx, h.router.config.ReaderDSN)
+	name, dsn := h.readerBackend()
+	conn, err := h.router.connectToBackend(ctx, name, dsn, h.connectOptions())
 	if err != nil {
 		h.sendError(fmt.Sprintf("failed to connect to reader: %v", err))
 		h.sendReadyForQuery('I')
@@ -281,31 +391,215 @@ func (h *ClientHandler) handleReadQuery(ctx context.Context, sql string) {
 
 // handleWriteQuery executes a write query on all writers
 func (h *ClientHandler) handleWriteQuery(ctx context.Context, sql string) {
-	err := h.router.ExecuteWrite(ctx, sql)
+	result, err := h.router.ExecuteWrite(ctx, sql, h.connectOptions())
 	if err != nil {
 		h.sendError(fmt.Sprintf("failed to execute write: %v", err))
 		h.sendReadyForQuery('I')
 		return
 	}
 
+	// Send row description for RETURNING rows
+	if len(result.Fields) > 0 {
+		buf, err := newRowDescription(result.Fields).Encode(nil)
+		if err == nil {
+			h.conn.Write(buf)
+		}
+	}
+
+	h.sendWriteResult(result)
+
+	// Send ready for query
+	h.sendReadyForQuery('I')
+}
+
+// sendWriteResult sends the rows and command tag of a write to the client
+func (h *ClientHandler) sendWriteResult(result *WriteResult) {
+	for _, values := range result.Rows {
+		dataRow := &pgproto3.DataRow{Values: values}
+		buf, err := dataRow.Encode(nil)
+		if err == nil {
+			h.conn.Write(buf)
+		}
+	}
+
 	// Send command complete
 	cmdComplete := &pgproto3.CommandComplete{
-		CommandTag: []byte("OK"),
+		CommandTag: []byte(result.CommandTag.String()),
 	}
 	buf, err := cmdComplete.Encode(nil)
 	if err == nil {
 		h.conn.Write(buf)
 	}
+}
 
-	// Send ready for query
+// newRowDescription converts backend field descriptions into a RowDescription message
+func newRowDescription(fieldDescs []pgconn.FieldDescription) *pgproto3.RowDescription {
+	rowDesc := &pgproto3.RowDescription{
+		Fields: make([]pgproto3.FieldDescription, len(fieldDescs)),
+	}
+	for i, fd := range fieldDescs {
+		rowDesc.Fields[i] = pgproto3.FieldDescription{
+			Name:                 []byte(fd.Name),
+			TableOID:             fd.TableOID,
+			TableAttributeNumber: fd.TableAttributeNumber,
+			DataTypeOID:          fd.DataTypeOID,
+			DataTypeSize:         fd.DataTypeSize,
+			TypeModifier:         fd.TypeModifier,
+			Format:               fd.Format,
+		}
+	}
+	return rowDesc
+}
+
+// handleListenQuery subscribes or unsubscribes the client from a notification channel
+func (h *ClientHandler) handleListenQuery(ctx context.Context, sql string) {
+	// A batch of LISTEN and UNLISTEN statements completes each in turn
+	for _, statement := range splitStatements(sql) {
+		tag, err := h.listen(ctx, statement, ClassifyQuery(statement))
+		if err != nil {
+			h.sendError(err.Error())
+			h.sendReadyForQuery('I')
+			return
+		}
+		h.sendWriteResult(&WriteResult{CommandTag: pgconn.NewCommandTag(tag)})
+	}
+	h.sendReadyForQuery('I')
+}
+
+// listen performs LISTEN or UNLISTEN through the notification hub and returns the command tag
+func (h *ClientHandler) listen(ctx context.Context, sql string, queryType QueryType) (string, error) {
+	channel, err := ParseChannelName(sql)
+	if err != nil {
+		return "", fmt.Errorf("invalid LISTEN/UNLISTEN statement: %v", err)
+	}
+
+	if queryType == QueryTypeUnlisten {
+		if channel == "*" {
+			h.router.notifications.UnsubscribeAll(h)
+		} else {
+			h.router.notifications.Unsubscribe(channel, h)
+		}
+		return "UNLISTEN", nil
+	}
+
+	if err := h.router.notifications.Subscribe(ctx, channel, h); err != nil {
+		return "", fmt.Errorf("failed to listen: %v", err)
+	}
+	return "LISTEN", nil
+}
+
+// handleNotifyQuery executes NOTIFY or pg_notify on the notify backend
+func (h *ClientHandler) handleNotifyQuery(ctx context.Context, sql string) {
+	result, err := h.router.ExecuteNotify(ctx, sql, h.connectOptions())
+	if err != nil {
+		h.sendError(fmt.Sprintf("failed to execute notify: %v", err))
+		h.sendReadyForQuery('I')
+		return
+	}
+
+	if len(result.Fields) > 0 {
+		buf, err := newRowDescription(result.Fields).Encode(nil)
+		if err == nil {
+			h.conn.Write(buf)
+		}
+	}
+
+	h.sendWriteResult(result)
+	h.sendReadyForQuery('I')
+}
+
+// handleCursorQuery executes a DECLARE, FETCH, MOVE or CLOSE statement
+func (h *ClientHandler) handleCursorQuery(ctx context.Context, sql string) {
+	result, err := h.executeCursor(ctx, sql, nil, nil, nil)
+	if err != nil {
+		h.sendQueryError(err)
+		h.sendReadyForQuery('I')
+		return
+	}
+
+	if len(result.Fields) > 0 {
+		buf, err := newRowDescription(result.Fields).Encode(nil)
+		if err == nil {
+			h.conn.Write(buf)
+		}
+	}
+
+	h.sendWriteResult(result)
 	h.sendReadyForQuery('I')
 }
 
+// sendNotification delivers a notification to the client, queueing it until
+// the current query finishes if the client is busy
+func (h *ClientHandler) sendNotification(notification *pgconn.Notification) {
+	msg := &pgproto3.NotificationResponse{
+		PID:     notification.PID,
+		Channel: notification.Channel,
+		Payload: notification.Payload,
+	}
+
+	h.notifyMu.Lock()
+	defer h.notifyMu.Unlock()
+
+	if h.busy {
+		h.pendingNotifications = append(h.pendingNotifications, msg)
+		return
+	}
+
+	buf, err := msg.Encode(nil)
+	if err == nil {
+		h.conn.Write(buf)
+	}
+}
+
+// setBusy marks the client as processing a message
+func (h *ClientHandler) setBusy() {
+	h.notifyMu.Lock()
+	h.busy = true
+	h.notifyMu.Unlock()
+}
+
+// connectOptions returns the backend connection options for a single query.
+// Notices are relayed to the client, with duplicates from fan-out writers dropped,
+// and backends are logged into with the session user's backend credentials.
+func (h *ClientHandler) connectOptions() *ConnectOptions {
+	relay := newNoticeRelay(h)
+	attrs := h.userAttributes()
+	return &ConnectOptions{OnNotice: relay.handle, Backend: attrs.Backend, ReadOnly: attrs.ReadOnly}
+}
+
 // sendError sends an error response to the client
 func (h *ClientHandler) sendError(message string) {
+	h.sendErrorCode("XX000", message)
+}
+
+// sendQueryError sends an error to the client, keeping the SQLSTATE of backend errors
+func (h *ClientHandler) sendQueryError(err error) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		h.sendErrorCode(pgErr.Code, pgErr.Message)
+		return
+	}
+	h.sendError(err.Error())
+}
+
+// sendErrorCode sends an error response with a specific SQLSTATE to the client
+func (h *ClientHandler) sendErrorCode(code, message string) {
 	errResp := &pgproto3.ErrorResponse{
 		Severity: "ERROR",
-		Code:     "XX000",
+		Code:     code,
+		Message:  message,
+	}
+	buf, err := errResp.Encode(nil)
+	if err == nil {
+		h.conn.Write(buf)
+	}
+}
+
+// sendFatal sends a FATAL error response to the client before the connection is closed
+func (h *ClientHandler) sendFatal(code, message string) {
+	errResp := &pgproto3.ErrorResponse{
+		Severity: "FATAL",
+		Code:     code,
 		Message:  message,
 	}
 	buf, err := errResp.Encode(nil)
@@ -316,6 +610,18 @@ func (h *ClientHandler) sendError(message string) {
 
 // sendReadyForQuery sends a ready for query message
 func (h *ClientHandler) sendReadyForQuery(status byte) {
+	h.notifyMu.Lock()
+	defer h.notifyMu.Unlock()
+
+	// Flush notifications that arrived while the query was running
+	for _, msg := range h.pendingNotifications {
+		buf, err := msg.Encode(nil)
+		if err == nil {
+			h.conn.Write(buf)
+		}
+	}
+	h.pendingNotifications = nil
+
 	ready := &pgproto3.ReadyForQuery{
 		TxStatus: status,
 	}
@@ -323,6 +629,9 @@ func (h *ClientHandler) sendReadyForQuery(status byte) {
 	if err == nil {
 		h.conn.Write(buf)
 	}
+
+	// The client is idle until it sends its next message
+	h.busy = false
 }
 
 // handleParse handles Parse message for prepared statements
@@ -376,7 +685,8 @@ func (h *ClientHandler) handleBind(msg *pgproto3.Bind) {
 
 // handleExecute handles Execute message to run a portal
 func (h *ClientHandler) handleExecute(msg *pgproto3.Execute) {
-	ctx := context.Background()
+	ctx, cancel := h.queryContext()
+	defer cancel()
 
 	// Get the portal
 	portal, exists := h.portals[msg.Portal]
@@ -392,10 +702,36 @@ func (h *ClientHandler) handleExecute(msg *pgproto3.Execute) {
 		return
 	}
 
+	if err := h.checkReadOnly(stmt.query); err != nil {
+		h.sendQueryError(err)
+		return
+	}
+	if err := h.checkRoleChange(stmt.query); err != nil {
+		h.sendQueryError(err)
+		return
+	}
+
 	// Execute based on query type
-	if stmt.queryType == QueryTypeRead {
+	switch stmt.queryType {
+	case QueryTypeRead:
 		h.executeReadPortal(ctx, stmt, portal, msg.MaxRows)
-	} else {
+	case QueryTypeListen, QueryTypeUnlisten:
+		tag, err := h.listen(ctx, stmt.query, stmt.queryType)
+		if err != nil {
+			h.sendError(err.Error())
+			return
+		}
+		h.sendWriteResult(&WriteResult{CommandTag: pgconn.NewCommandTag(tag)})
+	case QueryTypeNotify:
+		h.executeNotifyPortal(ctx, stmt, portal)
+	case QueryTypeCursor:
+		result, err := h.executeCursor(ctx, stmt.query, portal.params, stmt.paramOIDs, portal.formats)
+		if err != nil {
+			h.sendQueryError(err)
+			return
+		}
+		h.sendWriteResult(result)
+	default:
 		h.executeWritePortal(ctx, stmt, portal)
 	}
 }
@@ -403,7 +739,8 @@ func (h *ClientHandler) handleExecute(msg *pgproto3.Execute) {
 // executeReadPortal executes a read query from a portal
 func (h *ClientHandler) executeReadPortal(ctx context.Context, stmt *PreparedStatement, portal *Portal, maxRows uint32) {
 	// Connect to reader
-	conn, err := h.router.connectToBackend(ctx, h.router.config.ReaderDSN)
+	name, dsn := h.readerBackend()
+	conn, err := h.router.connectToBackend(ctx, name, dsn, h.connectOptions())
 	if err != nil {
 		h.sendError(fmt.Sprintf("failed to connect to reader: %v", err))
 		return
@@ -515,20 +852,35 @@ func (h *ClientHandler) executeWritePortal(ctx context.Context, stmt *PreparedSt
 	}
 
 	// Execute on all writers
-	err := h.router.ExecuteWriteWithParams(ctx, stmt.query, params...)
+	result, err := h.router.ExecuteWriteWithParams(ctx, stmt.query, h.connectOptions(), params...)
 	if err != nil {
 		h.sendError(fmt.Sprintf("failed to execute write: %v", err))
 		return
 	}
 
-	// Send command complete
-	cmdComplete := &pgproto3.CommandComplete{
-		CommandTag: []byte("OK"),
+	// Row description was already sent in response to Describe
+	h.sendWriteResult(result)
+}
+
+// executeNotifyPortal executes a NOTIFY or pg_notify query from a portal
+func (h *ClientHandler) executeNotifyPortal(ctx context.Context, stmt *PreparedStatement, portal *Portal) {
+	// Convert parameters to interface{} slice
+	params := make([]interface{}, len(portal.params))
+	for i, p := range portal.params {
+		if p == nil {
+			params[i] = nil
+		} else {
+			params[i] = string(p)
+		}
 	}
-	buf, err := cmdComplete.Encode(nil)
-	if err == nil {
-		h.conn.Write(buf)
+
+	result, err := h.router.ExecuteNotify(ctx, stmt.query, h.connectOptions(), params...)
+	if err != nil {
+		h.sendError(fmt.Sprintf("failed to execute notify: %v", err))
+		return
 	}
+
+	h.sendWriteResult(result)
 }
 
 // handleDescribe handles Describe message
@@ -551,27 +903,72 @@ func (h *ClientHandler) handleDescribe(msg *pgproto3.Describe) {
 			h.conn.Write(buf)
 		}
 
-		// Send NoData for now (we'd need to execute to get row description)
-		noData := &pgproto3.NoData{}
-		buf, err = noData.Encode(nil)
-		if err == nil {
-			h.conn.Write(buf)
-		}
+		h.describeResult(stmt)
 	} else if msg.ObjectType == 'P' {
 		// Describe portal
-		_, exists := h.portals[msg.Name]
+		portal, exists := h.portals[msg.Name]
 		if !exists {
 			h.sendError(fmt.Sprintf("portal %s does not exist", msg.Name))
 			return
 		}
 
-		// Send NoData for now
-		noData := &pgproto3.NoData{}
-		buf, err := noData.Encode(nil)
-		if err == nil {
-			h.conn.Write(buf)
+		h.describeResult(h.preparedStmts[portal.stmtName])
+	}
+}
+
+// describeResult sends the row description of a statement, or NoData if it returns no rows
+func (h *ClientHandler) describeResult(stmt *PreparedStatement) {
+	// Writes with a RETURNING clause are described by the primary writer and
+	// pg_notify calls by the notify backend, where they run
+	if stmt != nil && returnsWriteRows(stmt) {
+		ctx, cancel := h.queryContext()
+		defer cancel()
+		describe := h.router.DescribeWrite
+		if stmt.queryType == QueryTypeNotify {
+			describe = h.router.DescribeNotify
+		}
+		fields, err := describe(ctx, stmt.query, h.connectOptions())
+		if err != nil {
+			h.sendError(fmt.Sprintf("failed to describe statement: %v", err))
+			return
+		}
+		if len(fields) > 0 {
+			buf, err := newRowDescription(fields).Encode(nil)
+			if err == nil {
+				h.conn.Write(buf)
+			}
+			return
+		}
+	}
+
+	// FETCH is described by the connection holding the cursor
+	if stmt != nil && stmt.queryType == QueryTypeCursor {
+		if fields := h.describeCursor(stmt.query); len(fields) > 0 {
+			buf, err := newRowDescription(fields).Encode(nil)
+			if err == nil {
+				h.conn.Write(buf)
+			}
+			return
 		}
 	}
+
+	// Reads send their row description when executed, other statements return no rows
+	noData := &pgproto3.NoData{}
+	buf, err := noData.Encode(nil)
+	if err == nil {
+		h.conn.Write(buf)
+	}
+}
+
+// returnsWriteRows reports whether a statement routed to writers produces rows
+func returnsWriteRows(stmt *PreparedStatement) bool {
+	switch stmt.queryType {
+	case QueryTypeWrite:
+		return HasReturningClause(stmt.query)
+	case QueryTypeNotify:
+		return !isNotifyStatement(stmt.query)
+	}
+	return false
 }
 
 // handleClose handles Close message
@@ -594,6 +991,11 @@ func (h *ClientHandler) handleClose(msg *pgproto3.Close) {
 
 // sendAuthenticationOk sends authentication OK message
 func (h *ClientHandler) sendAuthenticationOk() error {
+	// Enforce per-user privileges before accepting the session
+	if err := h.admitSession(); err != nil {
+		return err
+	}
+
 	authOK := &pgproto3.AuthenticationOk{}
 	buf, err := authOK.Encode(nil)
 	if err != nil {
@@ -609,17 +1011,366 @@ func (h *ClientHandler) sendAuthenticationOk() error {
 	return nil
 }
 
+// userAttributes returns the privileges of the session user. Users without
+// an entry in the credentials, e.g. from the auth query, are unrestricted.
+func (h *ClientHandler) userAttributes() UserAttributes {
+	if user, exists := h.router.config.Credentials.GetAuthConfig().GetUser(h.username); exists {
+		return user.Attributes
+	}
+	return UserAttributes{}
+}
+
+// admitSession checks the user may connect to the database and registers
+// the session within the user's connection limit
+func (h *ClientHandler) admitSession() error {
+	attrs := h.userAttributes()
+
+	if !attrs.AllowsDatabase(h.database) {
+		message := fmt.Sprintf("permission denied for database \"%s\"", h.database)
+		h.sendFatal("42501", message) // insufficient_privilege
+		return fmt.Errorf("%s", message)
+	}
+
+	if err := h.router.registerSession(h, attrs.MaxConnections); err != nil {
+		h.sendFatal("53300", err.Error()) // too_many_connections
+		return err
+	}
+
+	return nil
+}
+
+// readOnlyExempt lists statements a read-only user may run although they classify as writes
+var readOnlyExempt = map[string]bool{
+	"begin": true, "start": true, "commit": true, "end": true, "rollback": true, "abort": true,
+	"set": true, "reset": true, "discard": true, "deallocate": true,
+}
+
+// checkReadOnly rejects statements that modify data when the user is
+// read-only. Every statement of a batch is checked, so a read can't carry a
+// write along. The backend enforces the same through
+// default_transaction_read_only, which also covers writes made by functions.
+func (h *ClientHandler) checkReadOnly(sql string) error {
+	if !h.userAttributes().ReadOnly {
+		return nil
+	}
+
+	for _, statement := range splitStatements(sql) {
+		if err := checkReadOnlyStatement(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkReadOnlyStatement checks a single statement of a read-only user
+func checkReadOnlyStatement(sql string) error {
+	queryType := ClassifyQuery(sql)
+	switch queryType {
+	case QueryTypeRead, QueryTypeListen, QueryTypeUnlisten:
+		return nil
+	case QueryTypeCursor:
+		// Only cursors over writes are refused; FETCH, MOVE and CLOSE are fine
+		stmt, err := parseCursorStatement(sql)
+		if err != nil || stmt.command != "DECLARE" || ClassifyQuery(stmt.query) == QueryTypeRead {
+			return nil
+		}
+		sql = stmt.query
+	}
+
+	words := strings.Fields(stripComments(sql))
+	if len(words) == 0 {
+		return nil
+	}
+	if queryType == QueryTypeWrite && readOnlyExempt[strings.ToLower(words[0])] && !enablesWrites(sql) {
+		return nil
+	}
+	message := fmt.Sprintf("cannot execute %s in a read-only transaction", strings.ToUpper(words[0]))
+	return &pgconn.PgError{Code: "25006", Message: message} // read_only_sql_transaction
+}
+
+// enablesWrites reports whether an otherwise exempt statement would lift
+// the read-only mode, e.g. BEGIN READ WRITE or SET transaction_read_only
+func enablesWrites(sql string) bool {
+	stripped := strings.ToLower(strings.Join(strings.Fields(stripLiterals(sql)), " "))
+	if strings.Contains(stripped, "read write") {
+		return true
+	}
+
+	words := strings.Fields(strings.ToLower(stripComments(sql)))
+	if len(words) == 0 || words[0] != "set" && words[0] != "reset" {
+		return false
+	}
+	for _, word := range words[1:] {
+		if word == "session" || word == "local" {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.Trim(word, `"`), "=")
+		return strings.Contains(name, "read_only")
+	}
+	return false
+}
+
+// checkRoleChange rejects statements that would leave the role the proxy set
+// for the session, so grants and row-level security of the mapped role can't
+// be bypassed with RESET ROLE or SET ROLE other. This is best effort, as DO
+// blocks and dynamic SQL in functions can still change the role; only a
+// separate backend login is a security boundary.
+func (h *ClientHandler) checkRoleChange(sql string) error {
+	backend := h.userAttributes().Backend
+	if backend == nil || !backend.SetsRole() {
+		return nil
+	}
+
+	for _, statement := range splitStatements(sql) {
+		if changesRole(statement) {
+			return &pgconn.PgError{Code: "42501", Message: "permission denied to change the session role"} // insufficient_privilege
+		}
+	}
+	return nil
+}
+
+// changesRole reports whether a statement sets or resets the role or the
+// session authorization. Comments are left out and words are split at
+// anything that can't be part of an identifier, so SET/**/ROLE is caught.
+func changesRole(sql string) bool {
+	if callsRoleSetConfig(sql) {
+		return true
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(stripComments(sql)), func(r rune) bool {
+		return r < 0x80 && !isIdentByte(byte(r))
+	})
+	if len(words) == 0 {
+		return false
+	}
+	switch words[0] {
+	case "discard":
+		// DISCARD ALL includes SET SESSION AUTHORIZATION DEFAULT
+		return len(words) > 1 && words[1] == "all"
+	case "set", "reset":
+		rest := words[1:]
+		if len(rest) > 1 && rest[0] == "session" && rest[1] == "authorization" {
+			return true
+		}
+		if len(rest) > 0 && (rest[0] == "session" || rest[0] == "local") {
+			rest = rest[1:]
+		}
+		return len(rest) > 0 && (rest[0] == "role" || rest[0] == "session_authorization")
+	}
+	return false
+}
+
+// callsRoleSetConfig reports whether a statement calls set_config for the
+// role or the session authorization. A setting name other than a plain
+// string literal can't be checked, so such calls count as well.
+func callsRoleSetConfig(sql string) bool {
+	stripped := strings.ToLower(stripLiterals(sql))
+	uncommented := stripComments(sql)
+	for offset := 0; ; {
+		idx := strings.Index(stripped[offset:], "set_config")
+		if idx < 0 {
+			return false
+		}
+		start, end := offset+idx, offset+idx+len("set_config")
+		offset = end
+		if start > 0 && isIdentByte(stripped[start-1]) || end < len(stripped) && isIdentByte(stripped[end]) {
+			continue
+		}
+		open := end + len(stripped[end:]) - len(strings.TrimLeft(stripped[end:], " \t\r\n"))
+		if open == len(stripped) || stripped[open] != '(' {
+			continue
+		}
+
+		// The first argument ends at the first comma outside parentheses
+		argEnd, depth := len(stripped), 0
+	scan:
+		for i := open + 1; i < len(stripped); i++ {
+			switch stripped[i] {
+			case '(':
+				depth++
+			case ')':
+				if depth == 0 {
+					argEnd = i
+					break scan
+				}
+				depth--
+			case ',':
+				if depth == 0 {
+					argEnd = i
+					break scan
+				}
+			}
+		}
+
+		// A plain literal is quoted at both ends with only doubled quotes inside
+		arg := strings.TrimSpace(uncommented[open+1 : argEnd])
+		if len(arg) < 2 || arg[0] != '\'' || arg[len(arg)-1] != '\'' ||
+			strings.Contains(strings.ReplaceAll(arg[1:len(arg)-1], "''", ""), "'") {
+			return true
+		}
+		name := strings.ToLower(strings.TrimSpace(strings.ReplaceAll(arg[1:len(arg)-1], "''", "'")))
+		if name == "role" || name == "session_authorization" {
+			return true
+		}
+	}
+}
+
+// readerBackend returns the name and DSN of the backend for reads, which is
+// the primary writer for users whose default routing is writer
+func (h *ClientHandler) readerBackend() (string, string) {
+	if h.userAttributes().DefaultRouting == RoutingWriter {
+		return writerBackendName(h.router.config.PrimaryWriter), h.router.config.WriterDSNs[h.router.config.PrimaryWriter]
+	}
+	return "reader", h.router.config.ReaderDSN
+}
+
+// authenticate applies the host-based access rules and runs the authentication method they select
+func (h *ClientHandler) authenticate(username, database string) error {
+	// Verify the client certificate maps to the requested user. This comes
+	// first so TLS_CLIENT_AUTH=require holds whatever the HBA rules allow.
+	certAuthenticated, err := h.authenticateClientCert(username)
+	if err != nil {
+		logAuthEvent(h.authEvent("auth_failure", HBAMethodCert, "certificate_mismatch"))
+		h.sendFatal("28000", err.Error()) // invalid_authorization_specification
+		return err
+	}
+
+	if h.router.config.HBA != nil {
+		rule := h.router.config.HBA.GetConfig().Match(h.tlsState != nil, database, username, h.remoteIP())
+		if rule == nil {
+			message := fmt.Sprintf("no pg_hba.conf entry for host \"%s\", user \"%s\", database \"%s\", %s", h.remoteIP(), username, database, h.sslDescription())
+			logAuthEvent(h.authEvent("auth_failure", "", "no_hba_entry"))
+			h.sendFatal("28000", message) // invalid_authorization_specification
+			return fmt.Errorf("%s", message)
+		}
+
+		// The rule's method is authoritative: a scram-sha-256 rule always
+		// runs SCRAM, even for users whose certificate would skip it
+		switch rule.Method {
+		case HBAMethodReject:
+			message := fmt.Sprintf("pg_hba.conf rejects connection for host \"%s\", user \"%s\", database \"%s\", %s", h.remoteIP(), username, database, h.sslDescription())
+			logAuthEvent(h.authEvent("auth_failure", rule.Method, "hba_reject"))
+			h.sendFatal("28000", message)
+			return fmt.Errorf("%s", message)
+		case HBAMethodTrust:
+			h.authenticated = true
+			return h.sendAuthenticationOk()
+		case HBAMethodCert:
+			tlsConfig := h.router.config.TLSConfig
+			mapName := tlsConfig.IdentMapName
+			if name, ok := rule.Options["map"]; ok {
+				mapName = name
+			}
+			cert := h.clientCertificate()
+			if cert == nil || !certificateMatchesUser(cert, tlsConfig.IdentMap, mapName, username) {
+				message := fmt.Sprintf("certificate authentication failed for user \"%s\"", username)
+				logAuthEvent(h.authEvent("auth_failure", rule.Method, "certificate_mismatch"))
+				h.sendFatal("28000", message)
+				return fmt.Errorf("%s", message)
+			}
+			h.authenticated = true
+			return h.sendAuthenticationOk()
+		default:
+			return h.performSCRAMAuth(username)
+		}
+	}
+
+	// Without HBA rules, no configured users means trust mode and
+	// CERT_SKIP_SCRAM_USERS lets a certificate replace the password
+	trustMode := len(h.router.config.Credentials.GetAuthConfig().Users) == 0 && h.router.authQuery == nil
+	if trustMode || certAuthenticated {
+		h.authenticated = true
+		return h.sendAuthenticationOk()
+	}
+
+	// Perform SCRAM-SHA-256 authentication
+	return h.performSCRAMAuth(username)
+}
+
+// authEvent creates an authentication event for the connection
+func (h *ClientHandler) authEvent(event, method, reason string) AuthEvent {
+	return AuthEvent{
+		Event:      event,
+		User:       h.username,
+		Database:   h.database,
+		RemoteAddr: h.remoteIP().String(),
+		Method:     method,
+		Reason:     reason,
+	}
+}
+
+// recordAuthFailure logs a failed password attempt, counts it towards a
+// lockout and delays the reply with exponential backoff
+func (h *ClientHandler) recordAuthFailure(reason string) {
+	event := h.authEvent("auth_failure", HBAMethodSCRAM, reason)
+
+	failures, lockedUntil, delay := h.router.authLimiter.RecordFailure(h.username, h.limiterAddr())
+	event.Failures = failures
+	logAuthEvent(event)
+
+	if !lockedUntil.IsZero() {
+		event.Event = "auth_lockout"
+		event.LockedUntil = &lockedUntil
+		logAuthEvent(event)
+	}
+
+	time.Sleep(delay)
+}
+
+// remoteIP returns the client's IP address, or nil if it isn't a TCP connection
+func (h *ClientHandler) remoteIP() net.IP {
+	if addr, ok := h.conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+// limiterAddr returns the address the auth limiter counts failures against.
+// It is empty for Unix socket clients, which have no address to tell them
+// apart and are limited per user only.
+func (h *ClientHandler) limiterAddr() string {
+	if ip := h.remoteIP(); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// sslDescription describes the connection's encryption the way PostgreSQL does in HBA errors
+func (h *ClientHandler) sslDescription() string {
+	if h.tlsState != nil {
+		return "SSL encryption"
+	}
+	return "no encryption"
+}
+
 // performSCRAMAuth performs SCRAM-SHA-256 authentication
 func (h *ClientHandler) performSCRAMAuth(username string) error {
+	// Refuse locked out users and addresses before doing any work
+	if lockedUntil := h.router.authLimiter.LockedUntil(username, h.limiterAddr()); !lockedUntil.IsZero() {
+		event := h.authEvent("auth_failure", HBAMethodSCRAM, "locked_out")
+		event.LockedUntil = &lockedUntil
+		logAuthEvent(event)
+		h.sendFatal("28000", "too many authentication failures, try again later")
+		return fmt.Errorf("user %s or address %s is locked out", username, h.remoteIP())
+	}
+
 	// Get user credentials
-	user, exists := h.router.config.AuthConfig.GetUser(username)
+	// Look up the user in the latest credentials so reloads take effect immediately
+	user, exists, err := h.router.lookupUser(h.ctx, username)
+	if err != nil {
+		log.Printf("Failed to look up user %s: %v", username, err)
+		h.sendFatal("08006", "authentication lookup failed") // connection_failure
+		return fmt.Errorf("failed to look up user %s: %w", username, err)
+	}
 	if !exists {
-		return fmt.Errorf("user %s not found", username)
+		// Run a mock exchange that always fails, so unknown users can't be told apart
+		user = mockUserCredentials(username)
 	}
 
 	// Send SASL authentication request
+	mechanisms := SCRAMMechanisms(h.channelBinding)
 	saslAuth := &pgproto3.AuthenticationSASL{
-		AuthMechanisms: []string{"SCRAM-SHA-256"},
+		AuthMechanisms: mechanisms,
 	}
 	buf, err := saslAuth.Encode(nil)
 	if err != nil {
@@ -630,10 +1381,10 @@ func (h *ClientHandler) performSCRAMAuth(username string) error {
 		return fmt.Errorf("failed to send SASL auth: %w", err)
 	}
 
-	// Create SCRAM server
-	scramServer := NewSCRAMServer(user, h.tlsState)
-
 	// Receive client-first-message
+	if err := h.backend.SetAuthType(pgproto3.AuthTypeSASL); err != nil {
+		return fmt.Errorf("failed to set auth type: %w", err)
+	}
 	msg, err := h.backend.Receive()
 	if err != nil {
 		return fmt.Errorf("failed to receive SASL initial response: %w", err)
@@ -644,13 +1395,24 @@ func (h *ClientHandler) performSCRAMAuth(username string) error {
 		return fmt.Errorf("expected SASLInitialResponse, got %T", msg)
 	}
 
-	if saslInitial.AuthMechanism != "SCRAM-SHA-256" {
+	supported := false
+	for _, mechanism := range mechanisms {
+		if saslInitial.AuthMechanism == mechanism {
+			supported = true
+		}
+	}
+	if !supported {
 		return fmt.Errorf("unsupported auth mechanism: %s", saslInitial.AuthMechanism)
 	}
 
+	// Create SCRAM server for the mechanism chosen by the client
+	scramServer := NewSCRAMServer(user, saslInitial.AuthMechanism, h.channelBinding)
+
 	// Process client-first-message
 	serverFirst, err := scramServer.HandleClientFirst(string(saslInitial.Data))
 	if err != nil {
+		h.recordAuthFailure("malformed_message")
+		h.sendFatal("08P01", err.Error()) // protocol_violation
 		return fmt.Errorf("SCRAM client-first failed: %w", err)
 	}
 
@@ -668,6 +1430,9 @@ func (h *ClientHandler) performSCRAMAuth(username string) error {
 	}
 
 	// Receive client-final-message
+	if err := h.backend.SetAuthType(pgproto3.AuthTypeSASLContinue); err != nil {
+		return fmt.Errorf("failed to set auth type: %w", err)
+	}
 	msg, err = h.backend.Receive()
 	if err != nil {
 		return fmt.Errorf("failed to receive SASL response: %w", err)
@@ -680,19 +1445,22 @@ func (h *ClientHandler) performSCRAMAuth(username string) error {
 
 	// Process client-final-message
 	serverFinal, err := scramServer.HandleClientFinal(string(saslResponse.Data))
+	if errors.Is(err, ErrSCRAMMalformed) {
+		h.recordAuthFailure("malformed_message")
+		h.sendFatal("08P01", err.Error()) // protocol_violation
+		return fmt.Errorf("SCRAM client-final failed: %w", err)
+	}
 	if err != nil {
 		// Authentication failed
-		log.Printf("Authentication failed for user %s: %v", username, err)
-		
-		// Send authentication error
-		errResp := &pgproto3.ErrorResponse{
-			Severity: "FATAL",
-			Code:     "28P01", // invalid_password
-			Message:  "password authentication failed for user \"" + username + "\"",
+		reason := "invalid_password"
+		if !exists {
+			reason = "unknown_user"
 		}
-		buf, _ := errResp.Encode(nil)
-		h.conn.Write(buf)
-		
+		h.recordAuthFailure(reason)
+
+		// Send authentication error
+		h.sendFatal("28P01", "password authentication failed for user \""+username+"\"") // invalid_password
+
 		return fmt.Errorf("authentication failed: %w", err)
 	}
 
@@ -711,6 +1479,7 @@ func (h *ClientHandler) performSCRAMAuth(username string) error {
 
 	// Authentication successful
 	h.authenticated = true
+	h.router.authLimiter.RecordSuccess(username, h.limiterAddr())
 	log.Printf("User %s authenticated successfully", username)
 
 	// Send authentication OK
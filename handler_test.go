@@ -0,0 +1,93 @@
+package main
+
+import "testing"
+
+func TestCheckReadOnlyStatement(t *testing.T) {
+	tests := []struct {
+		sql     string
+		allowed bool
+	}{
+		{"SELECT * FROM t", true},
+		{"SHOW search_path", true},
+		{"BEGIN", true},
+		{"BEGIN READ ONLY", true},
+		{"SET search_path = app", true},
+		{"RESET ALL", true},
+		{"FETCH 10 FROM c", true},
+		{"DECLARE c CURSOR FOR SELECT 1", true},
+		{"INSERT INTO t VALUES (1)", false},
+		{"EXPLAIN ANALYZE DELETE FROM t", false},
+		{"DECLARE c CURSOR FOR DELETE FROM t RETURNING id", false},
+		{"BEGIN READ WRITE", false},
+		{"START TRANSACTION ISOLATION LEVEL SERIALIZABLE, READ WRITE", false},
+		{"SET TRANSACTION READ WRITE", false},
+		{"SET default_transaction_read_only = off", false},
+		{"SET SESSION transaction_read_only TO off", false},
+		{`SET "default_transaction_read_only"=off`, false},
+		{"RESET default_transaction_read_only", false},
+		{"NOTIFY chan", false},
+		{"/* app */ SELECT 1", true},
+		{"-- c\nSELECT 1", true},
+		{"/* outer /* inner */ */ SHOW search_path", true},
+		{"/* x */ BEGIN", true},
+		{"-- c\nSET search_path = app", true},
+		{"/* x */ DECLARE c CURSOR FOR SELECT 1", true},
+		{"/* x */ SET transaction_read_only = off", false},
+		{"SET /* x */ transaction_read_only = off", false},
+		{"SET/**/transaction_read_only = off", false},
+		{"/* SELECT */ DELETE FROM t", false},
+	}
+
+	for _, tt := range tests {
+		err := checkReadOnlyStatement(tt.sql)
+		if (err == nil) != tt.allowed {
+			t.Errorf("checkReadOnlyStatement(%q) = %v, want allowed %v", tt.sql, err, tt.allowed)
+		}
+	}
+}
+
+func TestChangesRole(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want bool
+	}{
+		{"SET ROLE admin", true},
+		{"set local role admin", true},
+		{"SET SESSION ROLE NONE", true},
+		{"RESET ROLE", true},
+		{`SET "role" = 'admin'`, true},
+		{"SET role=admin", true},
+		{"SET SESSION AUTHORIZATION admin", true},
+		{"RESET SESSION AUTHORIZATION", true},
+		{"SET session_authorization TO admin", true},
+		{"DISCARD ALL", true},
+		{"SELECT set_config('role', 'admin', false)", true},
+		{"SELECT pg_catalog.set_config('Role', 'admin', false)", true},
+		{"SELECT set_config(concat('ro','le'),'admin',false)", true},
+		{"SELECT set_config('ro' || 'le', 'admin', false)", true},
+		{"SELECT set_config('ro'\n'le', 'admin', false)", true},
+		{"SELECT set_config($1, $2, false)", true},
+		{"SELECT set_config /* x */ ('session_authorization', 'admin', false)", true},
+		{"/* x */ RESET ROLE", true},
+		{"set/**/role admin", true},
+		{"-- c\nSET ROLE admin", true},
+		{"SET /* x */ SESSION /* y */ AUTHORIZATION admin", true},
+		{"/* x */ DISCARD /* y */ ALL", true},
+		{"SELECT set_config('application_name', 'role', false)", false},
+		{"SELECT set_config('search_path', 'app', false), 'set_config(role)'", false},
+		{"SET application_name = 'role'", false},
+		{"-- SET ROLE admin\nSELECT 1", false},
+		{"SET search_path = app", false},
+		{"SET SESSION search_path = app", false},
+		{"RESET ALL", false},
+		{"DISCARD PLANS", false},
+		{"SELECT 'set role admin'", false},
+		{"SELECT * FROM roles", false},
+	}
+
+	for _, tt := range tests {
+		if got := changesRole(tt.sql); got != tt.want {
+			t.Errorf("changesRole(%q) = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authentication methods of host-based access rules
+const (
+	HBAMethodTrust  = "trust"
+	HBAMethodReject = "reject"
+	HBAMethodSCRAM  = "scram-sha-256"
+	HBAMethodCert   = "cert"
+)
+
+// HBARule is a single line of a pg_hba.conf-style access file:
+//
+//	# TYPE     DATABASE  USER      ADDRESS        METHOD
+//	hostssl    all       billing   10.0.0.0/8     cert   map=cert
+//	host       all       all       127.0.0.1/32   scram-sha-256
+//	host       all       all       all            reject
+type HBARule struct {
+	ConnType  string            // host, hostssl or hostnossl
+	Databases []string          // Database names, "all" or "sameuser"
+	Users     []string          // Usernames or "all"
+	Network   *net.IPNet        // Client network, nil for all addresses
+	Method    string            // trust, reject, scram-sha-256 or cert
+	Options   map[string]string // Method options, e.g. map=NAME for cert
+	Line      int               // Line number in the file
+}
+
+// HBAConfig is an ordered list of access rules; the first matching rule wins
+type HBAConfig struct {
+	Rules []*HBARule
+}
+
+// ParseHBAFile parses a pg_hba.conf-style access file
+func ParseHBAFile(path string) (*HBAConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open HBA file: %w", err)
+	}
+	defer file.Close()
+
+	config := &HBAConfig{}
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		rule, err := parseHBARule(fields)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HBA entry at line %d: %w", lineNum, err)
+		}
+		rule.Line = lineNum
+		config.Rules = append(config.Rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read HBA file: %w", err)
+	}
+
+	return config, nil
+}
+
+// parseHBARule parses the fields of a single access rule
+func parseHBARule(fields []string) (*HBARule, error) {
+	if len(fields) < 5 {
+		return nil, fmt.Errorf("expected TYPE DATABASE USER ADDRESS METHOD [OPTIONS]")
+	}
+
+	rule := &HBARule{
+		ConnType:  fields[0],
+		Databases: strings.Split(fields[1], ","),
+		Users:     strings.Split(fields[2], ","),
+		Options:   make(map[string]string),
+	}
+
+	switch rule.ConnType {
+	case "host", "hostssl", "hostnossl":
+	default:
+		return nil, fmt.Errorf("unsupported connection type: %s", rule.ConnType)
+	}
+
+	// The address is "all", a CIDR, or an IP address followed by a netmask
+	rest := fields[4:]
+	switch address := fields[3]; {
+	case address == "all":
+	case strings.Contains(address, "/"):
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address: %w", err)
+		}
+		rule.Network = network
+	default:
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address: %s", address)
+		}
+		maskIP := net.ParseIP(fields[4])
+		if maskIP == nil {
+			return nil, fmt.Errorf("invalid netmask: %s", fields[4])
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, maskIP = ip4, maskIP.To4()
+		}
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("authentication method not provided")
+		}
+		rule.Network = &net.IPNet{IP: ip.Mask(net.IPMask(maskIP)), Mask: net.IPMask(maskIP)}
+		rest = rest[1:]
+	}
+
+	rule.Method = rest[0]
+	switch rule.Method {
+	case HBAMethodTrust, HBAMethodReject, HBAMethodSCRAM, HBAMethodCert:
+	default:
+		return nil, fmt.Errorf("unsupported authentication method: %s", rule.Method)
+	}
+
+	for _, option := range rest[1:] {
+		parts := strings.SplitN(option, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid option: %s", option)
+		}
+		rule.Options[parts[0]] = parts[1]
+	}
+
+	return rule, nil
+}
+
+// Match returns the first rule matching the connection, or nil if none does
+func (c *HBAConfig) Match(ssl bool, database, username string, ip net.IP) *HBARule {
+	for _, rule := range c.Rules {
+		if rule.ConnType == "hostssl" && !ssl || rule.ConnType == "hostnossl" && ssl {
+			continue
+		}
+		if !rule.matchesDatabase(database, username) || !matchesName(rule.Users, username) {
+			continue
+		}
+		if rule.Network != nil && (ip == nil || !rule.Network.Contains(ip)) {
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+// matchesDatabase reports whether the rule covers the database
+func (r *HBARule) matchesDatabase(database, username string) bool {
+	for _, name := range r.Databases {
+		if name == "sameuser" && database == username {
+			return true
+		}
+	}
+	return matchesName(r.Databases, database)
+}
+
+// matchesName reports whether the name is in the list or the list contains "all"
+func matchesName(names []string, name string) bool {
+	for _, n := range names {
+		if n == "all" || n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HBAManager holds the active access rules and reloads them when the file changes
+type HBAManager struct {
+	path    string
+	mu      sync.RWMutex
+	config  *HBAConfig
+	modTime time.Time
+}
+
+// NewHBAManager loads the access file at path
+func NewHBAManager(path string) (*HBAManager, error) {
+	m := &HBAManager{path: path}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload re-reads the access file. On error the previous rules stay active.
+func (m *HBAManager) Reload() error {
+	info, err := os.Stat(m.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat HBA file: %w", err)
+	}
+
+	config, err := ParseHBAFile(m.path)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Record the modification time even on failure so a broken file is reported once
+	m.modTime = info.ModTime()
+	if err != nil {
+		return err
+	}
+	m.config = config
+
+	return nil
+}
+
+// GetConfig returns the active access rules
+func (m *HBAManager) GetConfig() *HBAConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.config
+}
+
+// Watch reloads the access file whenever its modification time changes
+func (m *HBAManager) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			info, err := os.Stat(m.path)
+			if err != nil {
+				continue
+			}
+
+			m.mu.RLock()
+			changed := !info.ModTime().Equal(m.modTime)
+			m.mu.RUnlock()
+			if !changed {
+				continue
+			}
+
+			if err := m.Reload(); err != nil {
+				log.Printf("Failed to reload HBA file, keeping previous rules: %v", err)
+			} else {
+				log.Printf("HBA rules reloaded from %s", m.path)
+			}
+		}
+	}()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeHBAFile writes an access file to a temporary directory and returns its path
+func writeHBAFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pg_hba.conf")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseHBAFile(t *testing.T) {
+	path := writeHBAFile(t, `# TYPE  DATABASE  USER  ADDRESS  METHOD
+
+hostssl  app,reports  alice,bob  10.0.0.0/8  cert  map=certs  # trailing comment
+host     all          all        192.168.1.0  255.255.255.0  scram-sha-256
+host     sameuser     all        all          trust
+`)
+
+	config, err := ParseHBAFile(path)
+	if err != nil {
+		t.Fatalf("ParseHBAFile() error = %v", err)
+	}
+	if len(config.Rules) != 3 {
+		t.Fatalf("ParseHBAFile() returned %d rules, want 3", len(config.Rules))
+	}
+
+	cert := config.Rules[0]
+	if cert.Line != 3 || cert.ConnType != "hostssl" || cert.Method != HBAMethodCert || cert.Options["map"] != "certs" {
+		t.Errorf("rule 1 = %+v", cert)
+	}
+	if len(cert.Databases) != 2 || len(cert.Users) != 2 || cert.Network.String() != "10.0.0.0/8" {
+		t.Errorf("rule 1 databases %v, users %v, network %v", cert.Databases, cert.Users, cert.Network)
+	}
+	if masked := config.Rules[1]; masked.Line != 4 || masked.Network.String() != "192.168.1.0/24" || masked.Method != HBAMethodSCRAM {
+		t.Errorf("rule 2 = %+v, want 192.168.1.0/24 with scram-sha-256", masked)
+	}
+	if all := config.Rules[2]; all.Line != 5 || all.Network != nil {
+		t.Errorf("rule 3 = %+v, want all addresses", all)
+	}
+}
+
+func TestParseHBARuleErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"too few fields", "host all all trust"},
+		{"local connection type", "local all all all trust"},
+		{"unknown method", "host all all all md5"},
+		{"bad CIDR", "host all all 10.0.0.0/33 trust"},
+		{"bad address", "host all all db.example 255.0.0.0 trust"},
+		{"bad netmask", "host all all 10.0.0.0 255.0.0 trust"},
+		{"netmask without method", "host all all 10.0.0.0 255.0.0.0"},
+		{"option without value", "hostssl all all all cert map"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseHBAFile(writeHBAFile(t, "host all all all trust\n"+tt.line+"\n")); err == nil {
+				t.Errorf("ParseHBAFile(%q) succeeded", tt.line)
+			}
+		})
+	}
+}
+
+func TestHBAConfigMatch(t *testing.T) {
+	path := writeHBAFile(t, `
+host       all       mallory    all                           reject
+hostssl    all       alice      10.0.0.0/8                    cert
+hostnossl  all       alice      10.0.0.0/8                    scram-sha-256
+host       sameuser  all        192.168.1.0  255.255.255.0    trust
+host       reports   +analysts  all                           trust
+host       app,docs  bob,carol  127.0.0.1/32                  scram-sha-256
+host       all       all        all                           reject
+`)
+	config, err := ParseHBAFile(path)
+	if err != nil {
+		t.Fatalf("ParseHBAFile() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		ssl      bool
+		database string
+		username string
+		ip       string
+		wantLine int
+	}{
+		{"first match wins over later rules", true, "app", "mallory", "10.1.2.3", 2},
+		{"hostssl with TLS", true, "app", "alice", "10.1.2.3", 3},
+		{"hostnossl without TLS", false, "app", "alice", "10.1.2.3", 4},
+		{"CIDR outside the network", true, "app", "alice", "172.16.0.1", 8},
+		{"sameuser matches own database", false, "dave", "dave", "192.168.1.20", 5},
+		{"sameuser rejects other database", false, "app", "dave", "192.168.1.20", 8},
+		{"address and mask outside the network", false, "dave", "dave", "192.168.2.20", 8},
+		{"+group names are matched literally", false, "reports", "analysts", "127.0.0.1", 8},
+		{"+group name as username", false, "reports", "+analysts", "127.0.0.1", 6},
+		{"comma-separated lists", false, "docs", "carol", "127.0.0.1", 7},
+		{"comma-separated lists miss", false, "reports", "carol", "127.0.0.1", 8},
+		{"all catches the rest", false, "app", "erin", "127.0.0.1", 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := config.Match(tt.ssl, tt.database, tt.username, net.ParseIP(tt.ip))
+			if rule == nil {
+				t.Fatalf("Match() = nil, want line %d", tt.wantLine)
+			}
+			if rule.Line != tt.wantLine {
+				t.Errorf("Match() = line %d, want line %d", rule.Line, tt.wantLine)
+			}
+		})
+	}
+
+	if rule := (&HBAConfig{Rules: config.Rules[1:2]}).Match(true, "app", "alice", nil); rule != nil {
+		t.Errorf("Match() without a client address = line %d, want nil", rule.Line)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPProviderConfig configures the HTTP credential provider
+type HTTPProviderConfig struct {
+	Endpoint   string        // URL returning a CredentialFile JSON document
+	APIKey     string        // Sent as a bearer token, empty for none
+	Timeout    time.Duration // Timeout of a single request
+	Retries    int           // Retries after a failed request
+	SigningKey []byte        // HMAC-SHA256 key for X-Signature verification, nil to skip
+
+	HTTPClient *http.Client // Client for requests, nil for a default one
+}
+
+// HTTPCredentialProvider loads credentials from an HTTP API returning the
+// CredentialFile schema. Unchanged documents are detected with ETags.
+type HTTPCredentialProvider struct {
+	config *HTTPProviderConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	etag     string // ETag of the last applied document
+	nextETag string // ETag of the last loaded document, recorded once it is applied
+	loadedAt time.Time
+}
+
+func NewHTTPCredentialProvider(config *HTTPProviderConfig) *HTTPCredentialProvider {
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+	}
+
+	return &HTTPCredentialProvider{
+		config: config,
+		client: client,
+	}
+}
+
+func (p *HTTPCredentialProvider) GetCredentials(ctx context.Context) (map[string]*UserEntry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// A failed load must not commit the ETag of an earlier one
+	p.nextETag = p.etag
+
+	body, etag, err := p.fetch(ctx)
+	if errors.Is(err, ErrCredentialsNotModified) {
+		return nil, err
+	}
+	if err != nil {
+		// After the first load the manager keeps serving the last good credentials
+		if !p.loadedAt.IsZero() {
+			log.Printf("Credential API request failed, keeping credentials loaded at %s: %v", p.loadedAt.Format(time.RFC3339), err)
+		}
+		return nil, err
+	}
+
+	var credFile CredentialFile
+	if err := json.Unmarshal(body, &credFile); err != nil {
+		return nil, fmt.Errorf("failed to parse credential API response: %w", err)
+	}
+
+	p.nextETag = etag
+	return credFile.entries(), nil
+}
+
+// CommitCredentials records the ETag of the last load once the manager has
+// validated and applied its credentials, so a rejected document is loaded
+// again instead of being answered with 304 Not Modified
+func (p *HTTPCredentialProvider) CommitCredentials() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.etag = p.nextETag
+	p.loadedAt = time.Now()
+}
+
+func (p *HTTPCredentialProvider) SupportsReload() bool {
+	return true
+}
+
+// fetch requests the document, retrying network errors and 5xx and 429
+// responses with exponential backoff. It returns the verified body and its ETag.
+func (p *HTTPCredentialProvider) fetch(ctx context.Context) ([]byte, string, error) {
+	backoff := 500 * time.Millisecond
+	var lastErr error
+
+	for attempt := 0; attempt <= p.config.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+				backoff *= 2
+			case <-ctx.Done():
+				return nil, "", ctx.Err()
+			}
+		}
+
+		body, etag, retry, err := p.fetchOnce(ctx)
+		if err == nil || !retry {
+			return body, etag, err
+		}
+		lastErr = err
+	}
+
+	return nil, "", fmt.Errorf("credential API request failed after %d attempts: %w", p.config.Retries+1, lastErr)
+}
+
+// fetchOnce sends a single conditional request. It reports whether a failure is worth retrying.
+func (p *HTTPCredentialProvider) fetchOnce(ctx context.Context) ([]byte, string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.config.Endpoint, nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.APIKey)
+	}
+	if p.etag != "" {
+		req.Header.Set("If-None-Match", p.etag)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, "", true, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, "", true, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return nil, "", false, ErrCredentialsNotModified
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, "", true, fmt.Errorf("credential API returned status %d", resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", false, fmt.Errorf("credential API returned status %d", resp.StatusCode)
+	}
+
+	if err := p.verifySignature(body, resp.Header.Get("X-Signature")); err != nil {
+		return nil, "", false, err
+	}
+
+	return body, resp.Header.Get("ETag"), false, nil
+}
+
+// verifySignature checks the X-Signature header, "sha256=" followed by the
+// hex HMAC-SHA256 of the body, when a signing key is configured
+func (p *HTTPCredentialProvider) verifySignature(body []byte, header string) error {
+	if p.config.SigningKey == nil {
+		return nil
+	}
+
+	signature, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if header == "" || !strings.HasPrefix(header, "sha256=") || err != nil {
+		return fmt.Errorf("credential API response has a missing or malformed signature")
+	}
+
+	mac := hmac.New(sha256.New, p.config.SigningKey)
+	mac.Write(body)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return fmt.Errorf("credential API response signature mismatch")
+	}
+	return nil
+}
+
+// loadHTTPProviderConfig loads HTTP provider settings from environment
+func loadHTTPProviderConfig() (*HTTPProviderConfig, error) {
+	config := &HTTPProviderConfig{
+		Endpoint: os.Getenv("CREDENTIAL_API_ENDPOINT"),
+		APIKey:   os.Getenv("CREDENTIAL_API_KEY"),
+		Timeout:  10 * time.Second,
+		Retries:  3,
+	}
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("CREDENTIAL_API_ENDPOINT must be set")
+	}
+
+	if v := os.Getenv("CREDENTIAL_API_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid CREDENTIAL_API_TIMEOUT: %s", v)
+		}
+		config.Timeout = d
+	}
+
+	if v := os.Getenv("CREDENTIAL_API_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid CREDENTIAL_API_RETRIES: %s", v)
+		}
+		config.Retries = n
+	}
+
+	if key := os.Getenv("CREDENTIAL_API_HMAC_KEY"); key != "" {
+		config.SigningKey = []byte(key)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+const httpProviderDocument = `{"users":[{"username":"alice","password":"alice-password","read_only":true}]}`
+
+func signDocument(key, body string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHTTPProviderETag(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("Authorization") != "Bearer api-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(httpProviderDocument))
+	}))
+	defer server.Close()
+
+	provider := NewHTTPCredentialProvider(&HTTPProviderConfig{Endpoint: server.URL, APIKey: "api-key", Retries: 3})
+	ctx := context.Background()
+
+	credentials, err := provider.GetCredentials(ctx)
+	if err != nil {
+		t.Fatalf("GetCredentials() error = %v", err)
+	}
+	if alice := credentials["alice"]; alice == nil || alice.Password != "alice-password" || !alice.Attributes.ReadOnly {
+		t.Fatalf("alice = %+v, want read-only user with password alice-password", alice)
+	}
+
+	// The ETag is only sent once the document's credentials have been applied
+	if _, err := provider.GetCredentials(ctx); err != nil {
+		t.Fatalf("GetCredentials() for an uncommitted document error = %v", err)
+	}
+	provider.CommitCredentials()
+	if _, err := provider.GetCredentials(ctx); !errors.Is(err, ErrCredentialsNotModified) {
+		t.Fatalf("GetCredentials() with a matching ETag error = %v, want ErrCredentialsNotModified", err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("sent %d requests, want 3 (a 304 is not retried)", n)
+	}
+}
+
+func TestHTTPProviderRetries(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(httpProviderDocument))
+	}))
+	defer server.Close()
+
+	provider := NewHTTPCredentialProvider(&HTTPProviderConfig{Endpoint: server.URL, Retries: 1})
+	if _, err := provider.GetCredentials(context.Background()); err != nil {
+		t.Fatalf("GetCredentials() after a 503 error = %v", err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("sent %d requests, want 2", n)
+	}
+}
+
+func TestHTTPProviderDoesNotRetryClientErrors(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	provider := NewHTTPCredentialProvider(&HTTPProviderConfig{Endpoint: server.URL, Retries: 3})
+	if _, err := provider.GetCredentials(context.Background()); err == nil {
+		t.Fatal("GetCredentials() for a 403 succeeded, want an error")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("sent %d requests, want 1", n)
+	}
+}
+
+func TestHTTPProviderSignature(t *testing.T) {
+	tests := []struct {
+		name      string
+		signature string
+		wantErr   bool
+	}{
+		{"valid", signDocument("signing-key", httpProviderDocument), false},
+		{"wrong key", signDocument("other-key", httpProviderDocument), true},
+		{"missing", "", true},
+		{"no prefix", signDocument("signing-key", httpProviderDocument)[len("sha256="):], true},
+		{"not hex", "sha256=zz", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.signature != "" {
+					w.Header().Set("X-Signature", tt.signature)
+				}
+				w.Write([]byte(httpProviderDocument))
+			}))
+			defer server.Close()
+
+			provider := NewHTTPCredentialProvider(&HTTPProviderConfig{
+				Endpoint:   server.URL,
+				Retries:    3,
+				SigningKey: []byte("signing-key"),
+			})
+			_, err := provider.GetCredentials(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPProviderReloadsRejectedDocument(t *testing.T) {
+	var conditional atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" {
+			conditional.Add(1)
+		}
+		w.Header().Set("ETag", `"v2"`)
+		w.Write([]byte(`{"users":[{"username":"alice","password":"alice-password","max_connections":-1}]}`))
+	}))
+	defer server.Close()
+
+	cm := NewCredentialManager(NewHTTPCredentialProvider(&HTTPProviderConfig{Endpoint: server.URL}))
+	for i := 0; i < 2; i++ {
+		if err := cm.LoadCredentials(context.Background()); err == nil || errors.Is(err, ErrCredentialsNotModified) {
+			t.Fatalf("LoadCredentials() %d of an invalid document error = %v, want a validation error", i+1, err)
+		}
+	}
+	if n := conditional.Load(); n != 0 {
+		t.Errorf("sent %d conditional requests for a rejected document, want 0", n)
+	}
+}
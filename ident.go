@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// IdentMap maps external identities, such as client certificate names, to
+// PostgreSQL users. It reads files in pg_ident.conf format:
+//
+//	# MAPNAME  SYSTEM-USERNAME          PG-USERNAME
+//	cert       billing.svc.internal     billing
+//	cert       /^(.*)\.svc\.internal$   \1
+//
+// A system username starting with a slash is a regular expression; \1 in the
+// PostgreSQL username is replaced with its first capture group.
+type IdentMap struct {
+	entries []identEntry
+}
+
+// identEntry is a single line of an ident map
+type identEntry struct {
+	mapName    string
+	systemUser string
+	regex      *regexp.Regexp
+	pgUser     string
+}
+
+// LoadIdentMap loads an ident map from a file
+func LoadIdentMap(path string) (*IdentMap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ident map: %w", err)
+	}
+	defer file.Close()
+
+	im := &IdentMap{}
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid ident map entry at line %d: expected MAPNAME SYSTEM-USERNAME PG-USERNAME", lineNum)
+		}
+
+		entry := identEntry{mapName: fields[0], systemUser: fields[1], pgUser: fields[2]}
+		if strings.HasPrefix(entry.systemUser, "/") {
+			entry.regex, err = regexp.Compile(entry.systemUser[1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression at line %d: %w", lineNum, err)
+			}
+		}
+		im.entries = append(im.entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ident map: %w", err)
+	}
+
+	return im, nil
+}
+
+// Matches reports whether the system user may connect as the PostgreSQL user under the named map
+func (im *IdentMap) Matches(mapName, systemUser, pgUser string) bool {
+	for _, entry := range im.entries {
+		if entry.mapName != mapName {
+			continue
+		}
+
+		if entry.regex == nil {
+			if entry.systemUser == systemUser && entry.pgUser == pgUser {
+				return true
+			}
+			continue
+		}
+
+		match := entry.regex.FindStringSubmatch(systemUser)
+		if match == nil {
+			continue
+		}
+		expected := entry.pgUser
+		if len(match) > 1 {
+			expected = strings.ReplaceAll(expected, `\1`, match[1])
+		}
+		if expected == pgUser {
+			return true
+		}
+	}
+
+	return false
+}
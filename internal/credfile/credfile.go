@@ -0,0 +1,296 @@
+// Package credfile implements the encrypted credential file format: a
+// versioned envelope holding the key derivation parameters next to the
+// AES-256-GCM ciphertext, so the passphrase never has to be 32 bytes long.
+//
+// Layout (integers are big-endian):
+//
+//	magic       "PPXC"
+//	version     1 byte (1)
+//	kdf         1 byte (1 = argon2id, 2 = scrypt)
+//	params      3 x uint32: argon2id time, memory (KiB), threads;
+//	            scrypt N, r, p
+//	salt length 1 byte, followed by the salt
+//	nonce       12 bytes
+//	ciphertext  AES-256-GCM, with everything before it as additional data
+//
+// Files written before the envelope existed are a bare nonce and ciphertext
+// under the passphrase zero-padded or truncated to 32 bytes. Decrypt still
+// reads them; Encrypt never writes them.
+package credfile
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF identifies the key derivation function of an envelope
+type KDF byte
+
+const (
+	KDFArgon2id KDF = 1
+	KDFScrypt   KDF = 2
+)
+
+const (
+	version   = 1
+	keySize   = 32
+	saltSize  = 16
+	nonceSize = 12
+)
+
+var magic = []byte("PPXC")
+
+// Upper bounds on the parameters accepted when reading, so a tampered header
+// can't make the proxy allocate more than 1 GiB or spin for minutes
+const (
+	maxArgon2Time    = 10
+	maxArgon2Memory  = 1024 * 1024 // KiB
+	maxArgon2Threads = 255
+	maxScryptN       = 1 << 20
+	maxScryptR       = 32
+	maxScryptP       = 16
+	maxScryptMemory  = 1 << 30 // bytes, 128 * N * r
+)
+
+// Params selects a key derivation function and its cost parameters
+type Params struct {
+	KDF KDF
+
+	// argon2id
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint32
+
+	// scrypt
+	N, R, P uint32
+}
+
+// DefaultArgon2id follows the RFC 9106 second recommended option
+var DefaultArgon2id = Params{KDF: KDFArgon2id, Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// DefaultScrypt uses the N=2^15, r=8, p=1 interactive parameters
+var DefaultScrypt = Params{KDF: KDFScrypt, N: 1 << 15, R: 8, P: 1}
+
+// ParseKDF returns the default parameters for a KDF name
+func ParseKDF(name string) (Params, error) {
+	switch name {
+	case "argon2id", "":
+		return DefaultArgon2id, nil
+	case "scrypt":
+		return DefaultScrypt, nil
+	default:
+		return Params{}, fmt.Errorf("unknown KDF %q (use argon2id or scrypt)", name)
+	}
+}
+
+// String returns the KDF name
+func (k KDF) String() string {
+	switch k {
+	case KDFArgon2id:
+		return "argon2id"
+	case KDFScrypt:
+		return "scrypt"
+	default:
+		return fmt.Sprintf("kdf(%d)", byte(k))
+	}
+}
+
+func (p Params) validate() error {
+	switch p.KDF {
+	case KDFArgon2id:
+		if p.Time == 0 || p.Time > maxArgon2Time {
+			return fmt.Errorf("argon2id time %d out of range", p.Time)
+		}
+		if p.Threads == 0 || p.Threads > maxArgon2Threads {
+			return fmt.Errorf("argon2id threads %d out of range", p.Threads)
+		}
+		if p.Memory < 8*p.Threads || p.Memory > maxArgon2Memory {
+			return fmt.Errorf("argon2id memory %d KiB out of range", p.Memory)
+		}
+	case KDFScrypt:
+		if p.N < 2 || p.N&(p.N-1) != 0 || p.N > maxScryptN {
+			return fmt.Errorf("scrypt N %d must be a power of two up to %d", p.N, maxScryptN)
+		}
+		if p.R == 0 || p.R > maxScryptR || p.P == 0 || p.P > maxScryptP {
+			return fmt.Errorf("scrypt r=%d p=%d out of range", p.R, p.P)
+		}
+		if 128*uint64(p.N)*uint64(p.R) > maxScryptMemory {
+			return fmt.Errorf("scrypt N=%d r=%d needs more than %d MiB", p.N, p.R, maxScryptMemory>>20)
+		}
+	default:
+		return fmt.Errorf("unknown KDF %d", byte(p.KDF))
+	}
+	return nil
+}
+
+// deriveKey runs the KDF over the passphrase
+func (p Params) deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	switch p.KDF {
+	case KDFArgon2id:
+		return argon2.IDKey([]byte(passphrase), salt, p.Time, p.Memory, uint8(p.Threads), keySize), nil
+	case KDFScrypt:
+		return scrypt.Key([]byte(passphrase), salt, int(p.N), int(p.R), int(p.P), keySize)
+	default:
+		return nil, fmt.Errorf("unknown KDF %d", byte(p.KDF))
+	}
+}
+
+func (p Params) values() [3]uint32 {
+	if p.KDF == KDFScrypt {
+		return [3]uint32{p.N, p.R, p.P}
+	}
+	return [3]uint32{p.Time, p.Memory, p.Threads}
+}
+
+func paramsFromValues(kdf KDF, v [3]uint32) Params {
+	if kdf == KDFScrypt {
+		return Params{KDF: kdf, N: v[0], R: v[1], P: v[2]}
+	}
+	return Params{KDF: kdf, Time: v[0], Memory: v[1], Threads: v[2]}
+}
+
+// IsLegacy reports whether data predates the envelope format
+func IsLegacy(data []byte) bool {
+	return !bytes.HasPrefix(data, magic)
+}
+
+// Encrypt seals plaintext in an envelope under a key derived from passphrase
+func Encrypt(plaintext []byte, passphrase string, params Params) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	header := append([]byte{}, magic...)
+	header = append(header, version, byte(params.KDF))
+	for _, v := range params.values() {
+		header = binary.BigEndian.AppendUint32(header, v)
+	}
+	header = append(header, byte(len(salt)))
+	header = append(header, salt...)
+	header = append(header, nonce...)
+
+	key, err := params.deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(header, nonce, plaintext, header), nil
+}
+
+// Decrypt opens an envelope, or a legacy file if data has no envelope header
+func Decrypt(data []byte, passphrase string) ([]byte, error) {
+	if IsLegacy(data) {
+		return decryptLegacy(data, passphrase)
+	}
+
+	params, salt, nonce, headerLen, err := parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	key, err := params.deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, data[headerLen:], data[:headerLen])
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted file")
+	}
+	return plaintext, nil
+}
+
+// Inspect returns the KDF parameters of an envelope
+func Inspect(data []byte) (Params, error) {
+	if IsLegacy(data) {
+		return Params{}, errors.New("legacy format has no KDF parameters")
+	}
+	params, _, _, _, err := parseHeader(data)
+	return params, err
+}
+
+// parseHeader validates the envelope header and returns its fields
+func parseHeader(data []byte) (params Params, salt, nonce []byte, headerLen int, err error) {
+	const fixed = 4 + 1 + 1 + 12 + 1
+	if len(data) < fixed {
+		return Params{}, nil, nil, 0, errors.New("truncated header")
+	}
+	if data[4] != version {
+		return Params{}, nil, nil, 0, fmt.Errorf("unsupported format version %d", data[4])
+	}
+
+	var values [3]uint32
+	for i := range values {
+		values[i] = binary.BigEndian.Uint32(data[6+4*i:])
+	}
+	params = paramsFromValues(KDF(data[5]), values)
+	if err := params.validate(); err != nil {
+		return Params{}, nil, nil, 0, err
+	}
+
+	saltLen := int(data[fixed-1])
+	headerLen = fixed + saltLen + nonceSize
+	if saltLen < 8 || len(data) < headerLen {
+		return Params{}, nil, nil, 0, errors.New("truncated header")
+	}
+	salt = data[fixed : fixed+saltLen]
+	nonce = data[fixed+saltLen : headerLen]
+	return params, salt, nonce, headerLen, nil
+}
+
+// decryptLegacy opens the pre-envelope format
+func decryptLegacy(data []byte, passphrase string) ([]byte, error) {
+	key := make([]byte, keySize)
+	copy(key, passphrase)
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted file")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,153 @@
+package credfile
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+)
+
+// Cheap parameters keep the tests fast
+var (
+	testArgon2id = Params{KDF: KDFArgon2id, Time: 1, Memory: 64, Threads: 1}
+	testScrypt   = Params{KDF: KDFScrypt, N: 1 << 10, R: 8, P: 1}
+)
+
+func TestRoundTrip(t *testing.T) {
+	plaintext := []byte(`{"users":[{"username":"alice","password":"secret"}]}`)
+
+	for _, params := range []Params{testArgon2id, testScrypt} {
+		t.Run(params.KDF.String(), func(t *testing.T) {
+			data, err := Encrypt(plaintext, "correct horse battery staple", params)
+			if err != nil {
+				t.Fatalf("Encrypt() error = %v", err)
+			}
+			if IsLegacy(data) {
+				t.Fatal("Encrypt() wrote the legacy format")
+			}
+
+			got, err := Decrypt(data, "correct horse battery staple")
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("Decrypt() = %q, want %q", got, plaintext)
+			}
+
+			if _, err := Decrypt(data, "wrong passphrase"); err == nil {
+				t.Error("Decrypt() with the wrong passphrase succeeded")
+			}
+
+			inspected, err := Inspect(data)
+			if err != nil {
+				t.Fatalf("Inspect() error = %v", err)
+			}
+			if inspected != params {
+				t.Errorf("Inspect() = %+v, want %+v", inspected, params)
+			}
+		})
+	}
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	plaintext := []byte(`{"users":[]}`)
+	key := make([]byte, keySize)
+	copy(key, "short passphrase")
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	data := gcm.Seal(nonce, nonce, plaintext, nil)
+
+	if !IsLegacy(data) {
+		t.Fatal("IsLegacy() = false for a bare nonce and ciphertext")
+	}
+	got, err := Decrypt(data, "short passphrase")
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("Decrypt() = %q, want %q", got, plaintext)
+	}
+	if _, err := Inspect(data); err == nil {
+		t.Error("Inspect() of a legacy file succeeded")
+	}
+}
+
+func TestDecryptDetectsHeaderTampering(t *testing.T) {
+	data, err := Encrypt([]byte("secret"), "passphrase", testArgon2id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each edit leaves a header that parses but no longer matches the additional data
+	tests := map[string]func([]byte){
+		"time":  func(d []byte) { binary.BigEndian.PutUint32(d[6:], 2) },
+		"salt":  func(d []byte) { d[19] ^= 1 },
+		"nonce": func(d []byte) { d[19+saltSize] ^= 1 },
+	}
+	for name, tamper := range tests {
+		t.Run(name, func(t *testing.T) {
+			tampered := bytes.Clone(data)
+			tamper(tampered)
+			if _, err := Decrypt(tampered, "passphrase"); err == nil {
+				t.Error("Decrypt() of a tampered envelope succeeded")
+			}
+		})
+	}
+}
+
+func TestParseHeaderRejects(t *testing.T) {
+	header := func(kdf KDF, v [3]uint32, saltLen int) []byte {
+		data := append([]byte{}, magic...)
+		data = append(data, version, byte(kdf))
+		for _, x := range v {
+			data = binary.BigEndian.AppendUint32(data, x)
+		}
+		data = append(data, byte(saltLen))
+		return append(data, make([]byte, saltLen+nonceSize)...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"magic only", []byte("PPXC")},
+		{"short salt", header(KDFArgon2id, [3]uint32{1, 64, 1}, 4)},
+		{"truncated salt", header(KDFArgon2id, [3]uint32{1, 64, 1}, saltSize)[:30]},
+		{"unknown version", func() []byte {
+			data := header(KDFArgon2id, [3]uint32{1, 64, 1}, saltSize)
+			data[4] = 2
+			return data
+		}()},
+		{"unknown KDF", header(9, [3]uint32{1, 64, 1}, saltSize)},
+		{"argon2id time zero", header(KDFArgon2id, [3]uint32{0, 64, 1}, saltSize)},
+		{"argon2id time too high", header(KDFArgon2id, [3]uint32{maxArgon2Time + 1, 64, 1}, saltSize)},
+		{"argon2id memory too high", header(KDFArgon2id, [3]uint32{1, maxArgon2Memory + 1, 1}, saltSize)},
+		{"argon2id memory below threads", header(KDFArgon2id, [3]uint32{1, 8, 4}, saltSize)},
+		{"argon2id threads zero", header(KDFArgon2id, [3]uint32{1, 64, 0}, saltSize)},
+		{"scrypt N not a power of two", header(KDFScrypt, [3]uint32{1000, 8, 1}, saltSize)},
+		{"scrypt N too high", header(KDFScrypt, [3]uint32{maxScryptN << 1, 1, 1}, saltSize)},
+		{"scrypt r too high", header(KDFScrypt, [3]uint32{1 << 10, maxScryptR + 1, 1}, saltSize)},
+		{"scrypt p too high", header(KDFScrypt, [3]uint32{1 << 10, 8, maxScryptP + 1}, saltSize)},
+		{"scrypt memory too high", header(KDFScrypt, [3]uint32{maxScryptN, 16, 1}, saltSize)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, _, err := parseHeader(tt.data); err == nil {
+				t.Error("parseHeader() succeeded, want an error")
+			}
+			if _, err := Decrypt(tt.data, "passphrase"); err == nil {
+				t.Error("Decrypt() succeeded, want an error")
+			}
+		})
+	}
+
+	if _, _, _, _, err := parseHeader(header(KDFScrypt, [3]uint32{maxScryptN, 8, 1}, saltSize)); err != nil {
+		t.Errorf("parseHeader() at the scrypt memory limit error = %v", err)
+	}
+}
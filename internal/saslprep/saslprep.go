@@ -0,0 +1,153 @@
+// Package saslprep implements the SASLprep profile of stringprep (RFC 4013),
+// which SCRAM clients apply to passwords before hashing them.
+package saslprep
+
+import (
+	"errors"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/bidi"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrInvalidUTF8 is returned for strings that aren't valid UTF-8
+var ErrInvalidUTF8 = errors.New("saslprep: invalid UTF-8")
+
+// nonASCIISpace is RFC 3454 table C.1.2, mapped to SPACE
+var nonASCIISpace = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x00A0, 0x00A0, 1},
+		{0x1680, 0x1680, 1},
+		{0x2000, 0x200B, 1},
+		{0x202F, 0x202F, 1},
+		{0x205F, 0x205F, 1},
+		{0x3000, 0x3000, 1},
+	},
+}
+
+// mappedToNothing is RFC 3454 table B.1, removed from the input
+var mappedToNothing = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x00AD, 0x00AD, 1},
+		{0x034F, 0x034F, 1},
+		{0x1806, 0x1806, 1},
+		{0x180B, 0x180D, 1},
+		{0x200B, 0x200D, 1},
+		{0x2060, 0x2060, 1},
+		{0xFE00, 0xFE0F, 1},
+		{0xFEFF, 0xFEFF, 1},
+	},
+}
+
+// prohibited combines RFC 3454 tables C.1.2 and C.2.1 through C.9
+var prohibited = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x0000, 0x001F, 1}, // C.2.1 ASCII control characters
+		{0x007F, 0x00A0, 1}, // C.2.1, C.2.2 and C.1.2
+		{0x0340, 0x0341, 1}, // C.8 change display properties
+		{0x06DD, 0x06DD, 1}, // C.2.2 non-ASCII control characters
+		{0x070F, 0x070F, 1},
+		{0x1680, 0x1680, 1}, // C.1.2 non-ASCII space characters
+		{0x180E, 0x180E, 1},
+		{0x2000, 0x200F, 1}, // C.1.2, C.2.2 and C.8
+		{0x2028, 0x202F, 1}, // C.2.2, C.8 and C.1.2
+		{0x205F, 0x2063, 1}, // C.1.2 and C.2.2
+		{0x206A, 0x206F, 1}, // C.2.2 and C.8
+		{0x2FF0, 0x2FFB, 1}, // C.7 inappropriate for canonical representation
+		{0x3000, 0x3000, 1}, // C.1.2
+		{0xD800, 0xF8FF, 1}, // C.5 surrogate codes and C.3 private use
+		{0xFDD0, 0xFDEF, 1}, // C.4 non-character code points
+		{0xFEFF, 0xFEFF, 1}, // C.2.2
+		{0xFFF9, 0xFFFF, 1}, // C.2.2, C.6 and C.4
+	},
+	R32: []unicode.Range32{
+		{0x1D173, 0x1D17A, 1}, // C.2.2
+		{0x1FFFE, 0x1FFFF, 1}, // C.4
+		{0x2FFFE, 0x2FFFF, 1},
+		{0x3FFFE, 0x3FFFF, 1},
+		{0x4FFFE, 0x4FFFF, 1},
+		{0x5FFFE, 0x5FFFF, 1},
+		{0x6FFFE, 0x6FFFF, 1},
+		{0x7FFFE, 0x7FFFF, 1},
+		{0x8FFFE, 0x8FFFF, 1},
+		{0x9FFFE, 0x9FFFF, 1},
+		{0xAFFFE, 0xAFFFF, 1},
+		{0xBFFFE, 0xBFFFF, 1},
+		{0xCFFFE, 0xCFFFF, 1},
+		{0xDFFFE, 0xDFFFF, 1},
+		{0xE0001, 0xE0001, 1}, // C.9 tagging characters
+		{0xE0020, 0xE007F, 1},
+		{0xEFFFE, 0xEFFFF, 1},  // C.4
+		{0xF0000, 0x10FFFF, 1}, // C.3 private use and C.4
+	},
+}
+
+// String prepares a password with SASLprep. It fails if the string contains
+// prohibited characters or violates the bidirectional rules of RFC 3454
+// section 6. Unassigned code points are allowed, as for stored strings.
+func String(s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", ErrInvalidUTF8
+	}
+
+	// Mapping (RFC 4013 section 2.1)
+	mapped := make([]rune, 0, len(s))
+	for _, r := range s {
+		switch {
+		case unicode.Is(nonASCIISpace, r):
+			mapped = append(mapped, ' ')
+		case unicode.Is(mappedToNothing, r):
+		default:
+			mapped = append(mapped, r)
+		}
+	}
+
+	// Normalization (RFC 4013 section 2.2)
+	normalized := norm.NFKC.String(string(mapped))
+
+	// Prohibited output (RFC 4013 section 2.3) and bidi classes
+	var hasRandAL, hasL bool
+	runes := []rune(normalized)
+	for _, r := range runes {
+		if unicode.Is(prohibited, r) {
+			return "", fmt.Errorf("saslprep: prohibited character U+%04X", r)
+		}
+		switch bidiClass(r) {
+		case bidi.R, bidi.AL:
+			hasRandAL = true
+		case bidi.L:
+			hasL = true
+		}
+	}
+
+	// Bidirectional characters (RFC 4013 section 2.4)
+	if hasRandAL {
+		if hasL {
+			return "", fmt.Errorf("saslprep: string mixes left-to-right and right-to-left characters")
+		}
+		first, last := bidiClass(runes[0]), bidiClass(runes[len(runes)-1])
+		if first != bidi.R && first != bidi.AL || last != bidi.R && last != bidi.AL {
+			return "", fmt.Errorf("saslprep: right-to-left string must start and end with a right-to-left character")
+		}
+	}
+
+	return normalized, nil
+}
+
+// bidiClass returns the bidirectional class of a rune
+func bidiClass(r rune) bidi.Class {
+	props, _ := bidi.LookupRune(r)
+	return props.Class()
+}
+
+// Password prepares a password the way PostgreSQL and libpq do: with
+// SASLprep if possible, and unchanged if SASLprep rejects it.
+func Password(password string) string {
+	prepared, err := String(password)
+	if err != nil {
+		return password
+	}
+	return prepared
+}
@@ -0,0 +1,95 @@
+package saslprep
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	// The first examples are from RFC 4013 section 3
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"soft hyphen mapped to nothing", "I\u00ADX", "IX"},
+		{"no transformation", "user", "user"},
+		{"case preserved", "USER", "USER"},
+		{"NFKC of feminine ordinal", "ª", "a"},
+		{"NFKC of roman numeral nine", "Ⅸ", "IX"},
+		{"non-ASCII space mapped to space", "pass\u00A0word", "pass word"},
+		{"ideographic space mapped to space", "pass\u3000word", "pass word"},
+		{"zero width space mapped to space", "a\u200Bb", "a b"},
+		{"zero width joiner mapped to nothing", "a\u200Db", "ab"},
+		{"NFKC composes", "e\u0301", "é"},
+		{"fullwidth letters", "ａｂ", "ab"},
+		{"right-to-left string", "ا1ب", "ا1ب"},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := String(tt.in)
+			if err != nil {
+				t.Fatalf("String(%q) failed: %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("String(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStringProhibited(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"ASCII control character", "\u0007"},
+		{"delete", "a\u007Fb"},
+		{"non-ASCII control character", "a\u0085b"},
+		{"private use", "\uE000"},
+		{"supplementary private use", "\U000F0000"},
+		{"non-character", "\uFDD0"},
+		{"non-character at plane end", "\U0001FFFF"},
+		{"inappropriate for plain text", "\uFFFD\uFFF9"},
+		{"change display property", "a\u200Eb"},
+		{"tagging character", "\U000E0001"},
+		{"mixed bidi", "اa"},
+		{"right-to-left not at both ends", "ا1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := String(tt.in); err == nil {
+				t.Errorf("String(%q) = %q, want an error", tt.in, got)
+			}
+		})
+	}
+}
+
+func TestStringInvalidUTF8(t *testing.T) {
+	if _, err := String("a\xffb"); !errors.Is(err, ErrInvalidUTF8) {
+		t.Errorf("String() error = %v, want ErrInvalidUTF8", err)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"I\u00ADX", "IX"},
+		{"pass\u00A0word", "pass word"},
+		// Passwords SASLprep rejects are used as they are, like libpq does
+		{"\u0007bell", "\u0007bell"},
+		{"اa", "اa"},
+		{"a\xffb", "a\xffb"},
+	}
+
+	for _, tt := range tests {
+		if got := Password(tt.in); got != tt.want {
+			t.Errorf("Password(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
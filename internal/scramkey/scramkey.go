@@ -0,0 +1,66 @@
+// Package scramkey derives SCRAM-SHA-256 keys and builds verifiers in
+// PostgreSQL's pg_authid format, shared by the proxy and its tools.
+package scramkey
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/sausheong/pprox/internal/saslprep"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// DefaultIterations is the PBKDF2 iteration count for new verifiers, matching PostgreSQL
+const DefaultIterations = 4096
+
+// MinIterations is the lowest iteration count accepted for new verifiers
+const MinIterations = 4096
+
+// Derive computes the StoredKey and ServerKey of a password that has already
+// been normalized with SASLprep
+func Derive(password string, salt []byte, iterations int) (storedKey, serverKey []byte) {
+	saltedPassword := pbkdf2.Key([]byte(password), salt, iterations, 32, sha256.New)
+
+	clientKeyHMAC := hmac.New(sha256.New, saltedPassword)
+	clientKeyHMAC.Write([]byte("Client Key"))
+	storedKeyHash := sha256.Sum256(clientKeyHMAC.Sum(nil))
+
+	serverKeyHMAC := hmac.New(sha256.New, saltedPassword)
+	serverKeyHMAC.Write([]byte("Server Key"))
+
+	return storedKeyHash[:], serverKeyHMAC.Sum(nil)
+}
+
+// NewSalt returns a random 16-byte salt
+func NewSalt() ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return salt, nil
+}
+
+// Verifier builds a verifier for a password with a random salt:
+// SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>
+func Verifier(password string, iterations int) (string, error) {
+	if iterations < MinIterations {
+		return "", fmt.Errorf("iteration count %d is below the minimum of %d", iterations, MinIterations)
+	}
+
+	salt, err := NewSalt()
+	if err != nil {
+		return "", err
+	}
+
+	// Clients normalize passwords with SASLprep before hashing them
+	storedKey, serverKey := Derive(saslprep.Password(password), salt, iterations)
+
+	return fmt.Sprintf("SCRAM-SHA-256$%d:%s$%s:%s",
+		iterations,
+		base64.StdEncoding.EncodeToString(salt),
+		base64.StdEncoding.EncodeToString(storedKey),
+		base64.StdEncoding.EncodeToString(serverKey)), nil
+}
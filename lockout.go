@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthLockoutConfig configures brute-force protection for password authentication
+type AuthLockoutConfig struct {
+	MaxFailures     int           // Failures before a user is locked out, 0 to disable
+	MaxIPFailures   int           // Failures before a source address is locked out, 0 to disable
+	LockoutDuration time.Duration // How long a lockout lasts; failures are forgotten after the same time
+	BackoffBase     time.Duration // Delay before reporting the first failure, doubled for each further one
+	BackoffMax      time.Duration // Upper bound of the failure delay
+}
+
+// failureRecord tracks consecutive authentication failures of a user or address
+type failureRecord struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// AuthLimiter counts authentication failures per user and per source address
+type AuthLimiter struct {
+	config  *AuthLockoutConfig
+	mu      sync.Mutex
+	records map[string]*failureRecord
+}
+
+// NewAuthLimiter creates an auth limiter and starts pruning stale records
+func NewAuthLimiter(config *AuthLockoutConfig) *AuthLimiter {
+	l := &AuthLimiter{
+		config:  config,
+		records: make(map[string]*failureRecord),
+	}
+	go l.prune()
+	return l
+}
+
+// limiterKeys returns the record keys of a connection attempt; there is no
+// address key without a remote IP
+func limiterKeys(username, remoteIP string) []string {
+	if remoteIP == "" {
+		return []string{"user:" + username}
+	}
+	return []string{"user:" + username, "ip:" + remoteIP}
+}
+
+// maxFailures returns the lockout threshold of a record key
+func (l *AuthLimiter) maxFailures(key string) int {
+	if strings.HasPrefix(key, "ip:") {
+		return l.config.MaxIPFailures
+	}
+	return l.config.MaxFailures
+}
+
+// LockedUntil returns when the lockout of the user or address ends, or the
+// zero time if neither is locked out
+func (l *AuthLimiter) LockedUntil(username, remoteIP string) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var until time.Time
+	now := time.Now()
+	for _, key := range limiterKeys(username, remoteIP) {
+		if record, ok := l.records[key]; ok && record.lockedUntil.After(now) && record.lockedUntil.After(until) {
+			until = record.lockedUntil
+		}
+	}
+	return until
+}
+
+// RecordFailure counts a failed attempt. It returns the failure count, the
+// end of a lockout it triggered (zero if none) and how long to delay the reply.
+func (l *AuthLimiter) RecordFailure(username, remoteIP string) (int, time.Time, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	failures := 0
+	var lockedUntil time.Time
+	for _, key := range limiterKeys(username, remoteIP) {
+		// Failures are forgotten once they are old or a lockout has run out,
+		// so a single failure after a lockout doesn't lock out again
+		record, ok := l.records[key]
+		lockoutEnded := ok && !record.lockedUntil.IsZero() && !record.lockedUntil.After(now)
+		if !ok || lockoutEnded || now.Sub(record.lastFailure) > l.config.LockoutDuration {
+			record = &failureRecord{}
+			l.records[key] = record
+		}
+		record.failures++
+		record.lastFailure = now
+
+		if limit := l.maxFailures(key); limit > 0 && record.failures >= limit && !record.lockedUntil.After(now) {
+			record.lockedUntil = now.Add(l.config.LockoutDuration)
+			lockedUntil = record.lockedUntil
+		}
+		if record.failures > failures {
+			failures = record.failures
+		}
+	}
+
+	return failures, lockedUntil, l.backoff(failures)
+}
+
+// RecordSuccess forgets the failures of the user and address
+func (l *AuthLimiter) RecordSuccess(username, remoteIP string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range limiterKeys(username, remoteIP) {
+		delete(l.records, key)
+	}
+}
+
+// backoff returns the delay before reporting the given number of failures
+func (l *AuthLimiter) backoff(failures int) time.Duration {
+	delay := l.config.BackoffBase
+	for i := 1; i < failures && delay < l.config.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > l.config.BackoffMax {
+		delay = l.config.BackoffMax
+	}
+	return delay
+}
+
+// prune periodically drops records whose failures and lockouts have expired
+func (l *AuthLimiter) prune() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		l.mu.Lock()
+		for key, record := range l.records {
+			if now.Sub(record.lastFailure) > l.config.LockoutDuration && !record.lockedUntil.After(now) {
+				delete(l.records, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// mockSCRAMSecret keys the credentials of the mock SCRAM exchange
+var mockSCRAMSecret = func() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}()
+
+// mockUserCredentials returns credentials for an unknown user, so the SCRAM
+// exchange looks the same as for a real one. The salt is stable per username
+// and no password matches the keys.
+func mockUserCredentials(username string) *UserCredentials {
+	mac := hmac.New(sha256.New, mockSCRAMSecret)
+	mac.Write([]byte(username))
+	sum := mac.Sum(nil)
+
+	keys := make([]byte, 64)
+	rand.Read(keys)
+
+	return &UserCredentials{
+		Username:       username,
+		Salt:           sum[:16],
+		StoredKey:      keys[:32],
+		ServerKey:      keys[32:],
+		IterationCount: defaultSCRAMIterations,
+	}
+}
+
+// AuthEvent is a structured authentication event
+type AuthEvent struct {
+	Time        time.Time  `json:"time"`
+	Event       string     `json:"event"` // auth_failure or auth_lockout
+	User        string     `json:"user"`
+	Database    string     `json:"database,omitempty"`
+	RemoteAddr  string     `json:"remote_addr"`
+	Method      string     `json:"method,omitempty"`
+	Reason      string     `json:"reason"`
+	Failures    int        `json:"failures,omitempty"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+// logAuthEvent writes an authentication event as a single JSON log line
+func logAuthEvent(event AuthEvent) {
+	event.Time = time.Now().UTC()
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode auth event: %v", err)
+		return
+	}
+	log.Printf("auth_event %s", data)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const testLockoutDuration = 50 * time.Millisecond
+
+func newTestAuthLimiter(maxFailures, maxIPFailures int) *AuthLimiter {
+	return &AuthLimiter{
+		config: &AuthLockoutConfig{
+			MaxFailures:     maxFailures,
+			MaxIPFailures:   maxIPFailures,
+			LockoutDuration: testLockoutDuration,
+			BackoffBase:     10 * time.Millisecond,
+			BackoffMax:      50 * time.Millisecond,
+		},
+		records: make(map[string]*failureRecord),
+	}
+}
+
+func TestAuthLimiterThreshold(t *testing.T) {
+	l := newTestAuthLimiter(3, 0)
+
+	for i := 1; i < 3; i++ {
+		failures, lockedUntil, _ := l.RecordFailure("alice", "10.0.0.1")
+		if failures != i || !lockedUntil.IsZero() {
+			t.Fatalf("failure %d = (%d, %v), want no lockout", i, failures, lockedUntil)
+		}
+		if !l.LockedUntil("alice", "10.0.0.1").IsZero() {
+			t.Fatalf("locked out after %d failures", i)
+		}
+	}
+
+	failures, lockedUntil, _ := l.RecordFailure("alice", "10.0.0.1")
+	if failures != 3 || lockedUntil.IsZero() {
+		t.Fatalf("failure 3 = (%d, %v), want a lockout", failures, lockedUntil)
+	}
+	if until := l.LockedUntil("alice", "10.0.0.2"); !until.Equal(lockedUntil) {
+		t.Errorf("LockedUntil() from another address = %v, want %v", until, lockedUntil)
+	}
+
+	// Failures during the lockout don't extend it
+	if _, again, _ := l.RecordFailure("alice", "10.0.0.1"); !again.IsZero() {
+		t.Errorf("failure during the lockout locked out again until %v", again)
+	}
+
+	l.RecordSuccess("alice", "10.0.0.1")
+	if !l.LockedUntil("alice", "10.0.0.1").IsZero() {
+		t.Error("locked out after RecordSuccess()")
+	}
+}
+
+func TestAuthLimiterResetsAfterLockout(t *testing.T) {
+	l := newTestAuthLimiter(2, 0)
+
+	l.RecordFailure("alice", "")
+	if _, lockedUntil, _ := l.RecordFailure("alice", ""); lockedUntil.IsZero() {
+		t.Fatal("no lockout after 2 failures")
+	}
+
+	time.Sleep(testLockoutDuration + 10*time.Millisecond)
+	if until := l.LockedUntil("alice", ""); !until.IsZero() {
+		t.Fatalf("LockedUntil() after the lockout ended = %v", until)
+	}
+
+	// The count starts over, so one failure doesn't lock out again
+	failures, lockedUntil, _ := l.RecordFailure("alice", "")
+	if failures != 1 || !lockedUntil.IsZero() {
+		t.Errorf("failure after the lockout = (%d, %v), want (1, no lockout)", failures, lockedUntil)
+	}
+}
+
+func TestAuthLimiterSeparateLimits(t *testing.T) {
+	l := newTestAuthLimiter(3, 2)
+
+	// Different users from one address trip the address limit only
+	l.RecordFailure("alice", "10.0.0.1")
+	if _, lockedUntil, _ := l.RecordFailure("bob", "10.0.0.1"); lockedUntil.IsZero() {
+		t.Fatal("no address lockout after 2 failures")
+	}
+	if l.LockedUntil("carol", "10.0.0.1").IsZero() {
+		t.Error("another user from the locked address is not locked out")
+	}
+	if !l.LockedUntil("alice", "10.0.0.2").IsZero() {
+		t.Error("user locked out from another address below the user limit")
+	}
+
+	// Without a remote address only the user limit applies
+	l = newTestAuthLimiter(3, 1)
+	if _, lockedUntil, _ := l.RecordFailure("alice", ""); !lockedUntil.IsZero() {
+		t.Error("failure without a remote address triggered an address lockout")
+	}
+
+	// A zero limit disables the user lockout
+	l = newTestAuthLimiter(0, 0)
+	for i := 0; i < 10; i++ {
+		if _, lockedUntil, _ := l.RecordFailure("alice", "10.0.0.1"); !lockedUntil.IsZero() {
+			t.Fatalf("lockout with limits disabled after %d failures", i+1)
+		}
+	}
+}
+
+func TestAuthLimiterBackoff(t *testing.T) {
+	l := newTestAuthLimiter(0, 0)
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 10 * time.Millisecond},
+		{2, 20 * time.Millisecond},
+		{3, 40 * time.Millisecond},
+		{4, 50 * time.Millisecond},
+		{100, 50 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if got := l.backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	_, _, delay := l.RecordFailure("alice", "10.0.0.1")
+	if delay != 10*time.Millisecond {
+		t.Errorf("RecordFailure() delay = %v, want %v", delay, 10*time.Millisecond)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -23,6 +24,16 @@ func main() {
 	// Create router
 	router := NewRouter(config)
 
+	// Expose counters such as credential reloads via expvar
+	if config.MetricsAddr != "" {
+		go func() {
+			log.Printf("Serving metrics on %s/debug/vars", config.MetricsAddr)
+			if err := http.ListenAndServe(config.MetricsAddr, nil); err != nil {
+				log.Printf("Metrics listener failed: %v", err)
+			}
+		}()
+	}
+
 	// Start TCP listener
 	listener, err := net.Listen("tcp", config.ProxyAddr)
 	if err != nil {
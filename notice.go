@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// noticeRelay forwards backend notices to a client for the duration of one query.
+// When the same query runs on several writers, each writer raises the same
+// notices, so a notice is only forwarded the first time it is seen at a given
+// position. A notice raised N times by one backend is still forwarded N times.
+type noticeRelay struct {
+	h         *ClientHandler
+	mu        sync.Mutex
+	seen      map[*pgconn.PgConn]map[string]int
+	forwarded map[string]int
+}
+
+// newNoticeRelay creates a notice relay for a client
+func newNoticeRelay(h *ClientHandler) *noticeRelay {
+	return &noticeRelay{
+		h:         h,
+		seen:      make(map[*pgconn.PgConn]map[string]int),
+		forwarded: make(map[string]int),
+	}
+}
+
+// handle is a pgconn.NoticeHandler that relays the notice to the client
+func (nr *noticeRelay) handle(conn *pgconn.PgConn, notice *pgconn.Notice) {
+	key := notice.Severity + "\x00" + notice.Code + "\x00" + notice.Message + "\x00" + notice.Detail + "\x00" + notice.Hint
+
+	nr.mu.Lock()
+	counts, exists := nr.seen[conn]
+	if !exists {
+		counts = make(map[string]int)
+		nr.seen[conn] = counts
+	}
+	counts[key]++
+	duplicate := counts[key] <= nr.forwarded[key]
+	if !duplicate {
+		nr.forwarded[key] = counts[key]
+	}
+	nr.mu.Unlock()
+
+	if !duplicate {
+		nr.h.sendNotice(notice)
+	}
+}
+
+// sendNotice sends a NoticeResponse to the client
+func (h *ClientHandler) sendNotice(notice *pgconn.Notice) {
+	msg := &pgproto3.NoticeResponse{
+		Severity:            notice.Severity,
+		SeverityUnlocalized: notice.SeverityUnlocalized,
+		Code:                notice.Code,
+		Message:             notice.Message,
+		Detail:              notice.Detail,
+		Hint:                notice.Hint,
+		Position:            notice.Position,
+		InternalPosition:    notice.InternalPosition,
+		InternalQuery:       notice.InternalQuery,
+		Where:               notice.Where,
+		SchemaName:          notice.SchemaName,
+		TableName:           notice.TableName,
+		ColumnName:          notice.ColumnName,
+		DataTypeName:        notice.DataTypeName,
+		ConstraintName:      notice.ConstraintName,
+		File:                notice.File,
+		Line:                notice.Line,
+		Routine:             notice.Routine,
+	}
+	buf, err := msg.Encode(nil)
+	if err == nil {
+		h.conn.Write(buf)
+	}
+}
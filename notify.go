@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// NotificationHub keeps one dedicated LISTEN connection per channel on the
+// notify backend and fans notifications out to the subscribed clients
+type NotificationHub struct {
+	router    *Router
+	mu        sync.Mutex
+	listeners map[string]*channelListener
+}
+
+// channelListener is a backend connection listening on a single channel
+type channelListener struct {
+	channel     string
+	subscribers map[*ClientHandler]struct{}
+	cancel      context.CancelFunc
+}
+
+// NewNotificationHub creates a new notification hub
+func NewNotificationHub(router *Router) *NotificationHub {
+	return &NotificationHub{
+		router:    router,
+		listeners: make(map[string]*channelListener),
+	}
+}
+
+// Subscribe registers a client for notifications on a channel, starting a
+// backend listener if this is the first subscriber
+func (nh *NotificationHub) Subscribe(ctx context.Context, channel string, h *ClientHandler) error {
+	if nh.addSubscriber(channel, h) {
+		return nil
+	}
+
+	// Establish the listener before acknowledging the LISTEN so errors reach
+	// the client. The lock isn't held while dialing, which would hold up
+	// delivery on every channel.
+	conn, err := nh.listen(ctx, channel)
+	if err != nil {
+		return err
+	}
+
+	nh.mu.Lock()
+	defer nh.mu.Unlock()
+
+	// Another client may have started a listener on the channel meanwhile
+	if l, exists := nh.listeners[channel]; exists {
+		l.subscribers[h] = struct{}{}
+		conn.Close(context.Background())
+		return nil
+	}
+
+	listenCtx, cancel := context.WithCancel(context.Background())
+	l := &channelListener{
+		channel:     channel,
+		subscribers: map[*ClientHandler]struct{}{h: {}},
+		cancel:      cancel,
+	}
+	nh.listeners[channel] = l
+
+	go nh.run(listenCtx, l, conn)
+
+	log.Printf("Listening on channel %q", channel)
+	return nil
+}
+
+// addSubscriber adds a client to an existing listener, reporting whether the
+// channel already had one
+func (nh *NotificationHub) addSubscriber(channel string, h *ClientHandler) bool {
+	nh.mu.Lock()
+	defer nh.mu.Unlock()
+
+	l, exists := nh.listeners[channel]
+	if exists {
+		l.subscribers[h] = struct{}{}
+	}
+	return exists
+}
+
+// Unsubscribe removes a client from a channel, stopping the backend listener
+// once the last subscriber is gone
+func (nh *NotificationHub) Unsubscribe(channel string, h *ClientHandler) {
+	nh.mu.Lock()
+	defer nh.mu.Unlock()
+
+	nh.unsubscribeLocked(channel, h)
+}
+
+// UnsubscribeAll removes a client from every channel it listens on
+func (nh *NotificationHub) UnsubscribeAll(h *ClientHandler) {
+	nh.mu.Lock()
+	defer nh.mu.Unlock()
+
+	for channel := range nh.listeners {
+		nh.unsubscribeLocked(channel, h)
+	}
+}
+
+func (nh *NotificationHub) unsubscribeLocked(channel string, h *ClientHandler) {
+	l, exists := nh.listeners[channel]
+	if !exists {
+		return
+	}
+
+	delete(l.subscribers, h)
+	if len(l.subscribers) == 0 {
+		l.cancel()
+		delete(nh.listeners, channel)
+		log.Printf("Stopped listening on channel %q", channel)
+	}
+}
+
+// listen opens a connection to the notify backend and issues LISTEN on it
+func (nh *NotificationHub) listen(ctx context.Context, channel string) (*pgx.Conn, error) {
+	conn, err := nh.router.connectToBackend(ctx, nh.router.config.NotifyBackend, nh.router.config.NotifyDSN, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to notify backend: %w", err)
+	}
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		conn.Close(ctx)
+		return nil, fmt.Errorf("failed to listen on channel %s: %w", channel, err)
+	}
+
+	return conn, nil
+}
+
+// run waits for notifications on a listener connection until the listener is
+// stopped, reconnecting with backoff if the backend connection is lost
+func (nh *NotificationHub) run(ctx context.Context, l *channelListener, conn *pgx.Conn) {
+	backoff := time.Second
+
+	for {
+		for conn != nil {
+			notification, err := conn.WaitForNotification(ctx)
+			if err != nil {
+				conn.Close(context.Background())
+				conn = nil
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("Lost listener connection for channel %q: %v", l.channel, err)
+				break
+			}
+			backoff = time.Second
+			nh.deliver(l, notification)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		var err error
+		conn, err = nh.listen(ctx, l.channel)
+		if err != nil {
+			log.Printf("Failed to re-establish listener for channel %q: %v", l.channel, err)
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+		}
+	}
+}
+
+// deliver sends a notification to every subscriber of its channel
+func (nh *NotificationHub) deliver(l *channelListener, notification *pgconn.Notification) {
+	nh.mu.Lock()
+	subscribers := make([]*ClientHandler, 0, len(l.subscribers))
+	for h := range l.subscribers {
+		subscribers = append(subscribers, h)
+	}
+	nh.mu.Unlock()
+
+	for _, h := range subscribers {
+		h.sendNotification(notification)
+	}
+}
+
+// ParseChannelName extracts the channel name from a LISTEN, UNLISTEN or NOTIFY
+// statement, applying PostgreSQL identifier folding rules
+func ParseChannelName(sql string) (string, error) {
+	trimmed := strings.TrimSpace(skipLeadingComments(sql))
+	keywordEnd := strings.IndexAny(trimmed, " \t\r\n")
+	if keywordEnd == -1 {
+		return "", fmt.Errorf("channel name not provided")
+	}
+	rest := strings.TrimSpace(trimmed[keywordEnd:])
+
+	// Quoted identifiers keep their case, with "" as an escaped quote
+	if strings.HasPrefix(rest, `"`) {
+		var name strings.Builder
+		for i := 1; i < len(rest); i++ {
+			if rest[i] != '"' {
+				name.WriteByte(rest[i])
+				continue
+			}
+			if i+1 < len(rest) && rest[i+1] == '"' {
+				name.WriteByte('"')
+				i++
+				continue
+			}
+			return name.String(), nil
+		}
+		return "", fmt.Errorf("unterminated quoted channel name")
+	}
+
+	// Unquoted identifiers end at whitespace, the payload separator or the statement end
+	end := strings.IndexAny(rest, " \t\r\n,;")
+	if end == -1 {
+		end = len(rest)
+	}
+	if end == 0 {
+		return "", fmt.Errorf("channel name not provided")
+	}
+
+	return strings.ToLower(rest[:end]), nil
+}
@@ -3,9 +3,16 @@ package main
 import (
 	"context"
 	"fmt"
+	"log"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgconn/ctxwatch"
 )
 
 // QueryType represents the type of SQL query
@@ -14,17 +21,105 @@ type QueryType int
 const (
 	QueryTypeRead QueryType = iota
 	QueryTypeWrite
+	QueryTypeListen
+	QueryTypeUnlisten
+	QueryTypeNotify
+	QueryTypeCursor
 )
 
+// pgNotifyCall matches a call to the pg_notify function
+var pgNotifyCall = regexp.MustCompile(`(?i)\bpg_notify\s*\(`)
+
+// notifyStatement matches a NOTIFY statement and pgNotifySelect a SELECT that
+// starts with a pg_notify call; both are matched with literals stripped
+var (
+	notifyStatement = regexp.MustCompile(`(?is)^NOTIFY\s`)
+	pgNotifySelect  = regexp.MustCompile(`(?is)^SELECT\s+pg_notify\s*\(`)
+)
+
+// isNotifyStatement reports whether sql is a NOTIFY statement rather than a
+// pg_notify call
+func isNotifyStatement(sql string) bool {
+	return notifyStatement.MatchString(strings.TrimSpace(stripLiterals(sql)))
+}
+
+// isNotifyOnly reports whether sql is a single NOTIFY statement or a bare
+// SELECT pg_notify(...). Anything else that calls pg_notify, such as a write
+// with RETURNING pg_notify(...) or a batch ending in one, has to be fanned out
+// like any other write or the writers would diverge.
+func isNotifyOnly(sql string) bool {
+	stripped := strings.TrimSpace(stripLiterals(sql))
+	stripped = strings.TrimSpace(strings.TrimSuffix(stripped, ";"))
+	if strings.Contains(stripped, ";") {
+		return false
+	}
+
+	if notifyStatement.MatchString(stripped) {
+		return true
+	}
+
+	loc := pgNotifySelect.FindStringIndex(stripped)
+	if loc == nil {
+		return false
+	}
+	// The call's closing parenthesis has to end the statement: no FROM,
+	// WHERE or further select list items
+	depth := 1
+	for i := loc[1]; i < len(stripped); i++ {
+		switch stripped[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i == len(stripped)-1
+			}
+		}
+	}
+	return false
+}
+
 // ClassifyQuery determines if a query is a read or write operation
 func ClassifyQuery(sql string) QueryType {
-	trimmed := strings.TrimSpace(sql)
+	trimmed := strings.TrimSpace(skipLeadingComments(sql))
 	upper := strings.ToUpper(trimmed)
 
+	// LISTEN/NOTIFY are handled by the notification hub, not fanned out
+	if strings.HasPrefix(upper, "LISTEN") {
+		return QueryTypeListen
+	}
+	if strings.HasPrefix(upper, "UNLISTEN") {
+		return QueryTypeUnlisten
+	}
+	if isNotifyOnly(trimmed) {
+		return QueryTypeNotify
+	}
+	if pgNotifyCall.MatchString(stripLiterals(trimmed)) {
+		// pg_notify in a larger statement can't go to the reader, which is
+		// read-only, nor only to the notify backend, which would skip the
+		// statement's writes on the other writers
+		return QueryTypeWrite
+	}
+
+	// Cursor statements run on the connection pinned to the cursor
+	if strings.HasPrefix(upper, "DECLARE") ||
+		strings.HasPrefix(upper, "FETCH") ||
+		strings.HasPrefix(upper, "MOVE") ||
+		strings.HasPrefix(upper, "CLOSE") {
+		return QueryTypeCursor
+	}
+
+	// EXPLAIN ANALYZE executes the statement, so it is routed like the statement
+	if strings.HasPrefix(upper, "EXPLAIN") {
+		if inner, analyze := explainedStatement(trimmed); analyze && ClassifyQuery(inner) != QueryTypeRead {
+			return QueryTypeWrite
+		}
+		return QueryTypeRead
+	}
+
 	// Check for read operations
 	if strings.HasPrefix(upper, "SELECT") ||
-		strings.HasPrefix(upper, "SHOW") ||
-		strings.HasPrefix(upper, "EXPLAIN") {
+		strings.HasPrefix(upper, "SHOW") {
 		return QueryTypeRead
 	}
 
@@ -32,39 +127,400 @@ func ClassifyQuery(sql string) QueryType {
 	return QueryTypeWrite
 }
 
+// mixesListen reports whether a batch combines LISTEN or UNLISTEN with other
+// statements. The notification hub handles LISTEN and UNLISTEN itself, so
+// the other statements would otherwise be dropped or run without them.
+func mixesListen(sql string) bool {
+	statements := splitStatements(sql)
+	listens := 0
+	for _, statement := range statements {
+		if queryType := ClassifyQuery(statement); queryType == QueryTypeListen || queryType == QueryTypeUnlisten {
+			listens++
+		}
+	}
+	return listens > 0 && listens < len(statements)
+}
+
+// explainAnalyzeOption matches the ANALYZE option of a parenthesized EXPLAIN
+// option list, and explainAnalyzeOff one that turns it off
+var (
+	explainAnalyzeOption = regexp.MustCompile(`(?i)\banaly[sz]e\b`)
+	explainAnalyzeOff    = regexp.MustCompile(`(?i)\banaly[sz]e\s+(false|off|0)\b`)
+)
+
+// explainedStatement returns the statement an EXPLAIN explains and whether
+// the ANALYZE option executes it
+func explainedStatement(sql string) (string, bool) {
+	stripped := stripLiterals(sql)
+	rest := strings.TrimLeft(stripped[len("EXPLAIN"):], " \t\r\n")
+	offset := len(stripped) - len(rest)
+	analyze := false
+
+	if strings.HasPrefix(rest, "(") {
+		end := strings.IndexByte(rest, ')')
+		if end < 0 {
+			return "", false
+		}
+		options := rest[1:end]
+		analyze = explainAnalyzeOption.MatchString(options) && !explainAnalyzeOff.MatchString(options)
+		offset += end + 1
+	} else {
+		// Legacy syntax: EXPLAIN [ANALYZE] [VERBOSE] statement
+		for {
+			word := rest
+			if i := strings.IndexAny(rest, " \t\r\n"); i >= 0 {
+				word = rest[:i]
+			}
+			switch strings.ToUpper(word) {
+			case "ANALYZE", "ANALYSE":
+				analyze = true
+			case "VERBOSE":
+			default:
+				return strings.TrimSpace(sql[offset:]), analyze
+			}
+			next := strings.TrimLeft(rest[len(word):], " \t\r\n")
+			offset += len(rest) - len(next)
+			rest = next
+		}
+	}
+
+	return strings.TrimSpace(sql[offset:]), analyze
+}
+
+// returningClause matches a RETURNING clause in a write statement
+var returningClause = regexp.MustCompile(`(?i)\bRETURNING\b`)
+
+// HasReturningClause reports whether a write query returns rows
+func HasReturningClause(sql string) bool {
+	return returningClause.MatchString(stripLiterals(sql))
+}
+
+// dollarQuoteTag matches the opening tag of a dollar-quoted string
+var dollarQuoteTag = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// stripLiterals blanks out string literals, quoted identifiers and comments,
+// so keyword matching only sees the statement's own tokens. The result has
+// the same length as sql, so positions in it apply to sql as well.
+func stripLiterals(sql string) string {
+	return blankSQL(sql, true)
+}
+
+// stripComments blanks out comments only, keeping literals and quoted
+// identifiers. Like stripLiterals, it keeps the length of sql.
+func stripComments(sql string) string {
+	return blankSQL(sql, false)
+}
+
+// blankSQL blanks out comments and, if literals is set, string literals and
+// quoted identifiers
+func blankSQL(sql string, literals bool) string {
+	b := []byte(sql)
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		j := i
+		comment := false
+		switch {
+		case c == '\'' || c == '"':
+			// E'...' strings use backslash escapes; doubled quotes escape everywhere
+			backslash := c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e') &&
+				(i == 1 || !isIdentByte(sql[i-2]))
+			j = i + 1
+			for j < len(sql) {
+				if backslash && sql[j] == '\\' {
+					j += 2
+					continue
+				}
+				if sql[j] == c {
+					if j+1 < len(sql) && sql[j+1] == c {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			j++
+		case c == '-' || c == '/':
+			j = commentEnd(sql, i)
+			comment = j > i
+		case c == '$' && (i == 0 || !isIdentByte(sql[i-1])):
+			if tag := dollarQuoteTag.FindString(sql[i:]); tag != "" {
+				end := strings.Index(sql[i+len(tag):], tag)
+				if end < 0 {
+					j = len(sql)
+				} else {
+					j = i + len(tag) + end + len(tag)
+				}
+			}
+		}
+
+		if j == i {
+			i++
+			continue
+		}
+		if j > len(sql) {
+			j = len(sql)
+		}
+		if !comment && !literals {
+			i = j
+			continue
+		}
+		for ; i < j; i++ {
+			b[i] = ' '
+		}
+	}
+	return string(b)
+}
+
+// commentEnd returns the end of the comment starting at sql[i], or i if no
+// comment starts there
+func commentEnd(sql string, i int) int {
+	switch {
+	case strings.HasPrefix(sql[i:], "--"):
+		if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+			return i + end
+		}
+		return len(sql)
+	case strings.HasPrefix(sql[i:], "/*"):
+		// Block comments nest in PostgreSQL
+		depth := 0
+		j := i
+		for j < len(sql) {
+			if strings.HasPrefix(sql[j:], "/*") {
+				depth++
+				j += 2
+			} else if strings.HasPrefix(sql[j:], "*/") {
+				depth--
+				j += 2
+				if depth == 0 {
+					break
+				}
+			} else {
+				j++
+			}
+		}
+		return j
+	}
+	return i
+}
+
+// skipLeadingComments returns sql from its first token on, past any
+// whitespace and comments before it
+func skipLeadingComments(sql string) string {
+	for {
+		sql = strings.TrimLeftFunc(sql, unicode.IsSpace)
+		end := commentEnd(sql, 0)
+		if end == 0 {
+			return sql
+		}
+		sql = sql[end:]
+	}
+}
+
+// splitStatements splits a simple query batch into its statements
+func splitStatements(sql string) []string {
+	stripped := stripLiterals(sql)
+	var statements []string
+	start := 0
+	for i := 0; i <= len(stripped); i++ {
+		if i < len(stripped) && stripped[i] != ';' {
+			continue
+		}
+		// Segments holding only comments are not statements
+		if strings.TrimSpace(stripped[start:i]) != "" {
+			statements = append(statements, strings.TrimSpace(sql[start:i]))
+		}
+		start = i + 1
+	}
+	return statements
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= 0x80
+}
+
+// writerCommitTimeout bounds the commits of a fan-out write, which run
+// detached from the query's context
+const writerCommitTimeout = 30 * time.Second
+
+// WriteResult holds the outcome of a fan-out write as seen by the primary writer
+type WriteResult struct {
+	CommandTag pgconn.CommandTag
+	Fields     []pgconn.FieldDescription
+	Rows       [][][]byte
+}
+
 // Router handles query routing to appropriate backends
 type Router struct {
-	config *Config
+	config        *Config
+	notifications *NotificationHub
+	sessionsMu    sync.Mutex
+	sessions      map[*ClientHandler]struct{}
+	authQuery     *AuthQueryCache // nil if auth_query is disabled
+	authLimiter   *AuthLimiter
 }
 
 // NewRouter creates a new Router instance
 func NewRouter(config *Config) *Router {
-	return &Router{config: config}
+	r := &Router{
+		config:      config,
+		sessions:    make(map[*ClientHandler]struct{}),
+		authLimiter: NewAuthLimiter(config.AuthLockout),
+	}
+	r.notifications = NewNotificationHub(r)
+
+	if config.AuthQuery != nil {
+		r.authQuery = NewAuthQueryCache(r, config.AuthQuery)
+	}
+
+	if config.DisconnectRemovedUsers && config.Credentials != nil {
+		config.Credentials.OnReload(r.disconnectRemovedUsers)
+	}
+
+	return r
 }
 
-// connectToBackend creates a connection to a backend database with TLS if configured
-func (r *Router) connectToBackend(ctx context.Context, dsn string) (*pgx.Conn, error) {
+// registerSession tracks an authenticated client session. It refuses the
+// session if the user already has maxConnections sessions (0 for no limit).
+func (r *Router) registerSession(h *ClientHandler, maxConnections int) error {
+	r.sessionsMu.Lock()
+	defer r.sessionsMu.Unlock()
+
+	if maxConnections > 0 {
+		count := 0
+		for session := range r.sessions {
+			if session.username == h.username {
+				count++
+			}
+		}
+		if count >= maxConnections {
+			return fmt.Errorf("too many connections for role \"%s\"", h.username)
+		}
+	}
+
+	r.sessions[h] = struct{}{}
+	return nil
+}
+
+// unregisterSession stops tracking a client session
+func (r *Router) unregisterSession(h *ClientHandler) {
+	r.sessionsMu.Lock()
+	defer r.sessionsMu.Unlock()
+	delete(r.sessions, h)
+}
+
+// disconnectRemovedUsers terminates sessions of users that no longer exist
+// after a credential reload
+func (r *Router) disconnectRemovedUsers(previous, current *AuthConfig) {
+	// Without users the proxy is in trust mode, so nobody is removed
+	if len(current.Users) == 0 {
+		return
+	}
+
+	r.sessionsMu.Lock()
+	var removed []*ClientHandler
+	for h := range r.sessions {
+		// Only users dropped from the credentials are affected; users found
+		// through the auth query were never in them
+		_, existed := previous.GetUser(h.username)
+		if _, exists := current.GetUser(h.username); existed && !exists {
+			removed = append(removed, h)
+		}
+	}
+	r.sessionsMu.Unlock()
+
+	for _, h := range removed {
+		log.Printf("Disconnecting session of removed user %s from %s", h.username, h.conn.RemoteAddr())
+		h.terminate(fmt.Sprintf("terminating connection because user \"%s\" was removed", h.username))
+	}
+}
+
+// ConnectOptions holds per-session settings applied to backend connections
+type ConnectOptions struct {
+	OnNotice pgconn.NoticeHandler // Receives notices raised by the backend
+	Backend  *BackendCredentials  // Logins of the session user, nil to use the DSN's
+	ReadOnly bool                 // Start the session with default_transaction_read_only on
+}
+
+// lookupUser finds a user's credentials, first in the configured credentials
+// and then with the auth query
+func (r *Router) lookupUser(ctx context.Context, username string) (*UserCredentials, bool, error) {
+	if user, exists := r.config.Credentials.GetAuthConfig().GetUser(username); exists {
+		return user, true, nil
+	}
+
+	if r.authQuery == nil {
+		return nil, false, nil
+	}
+	return r.authQuery.GetUser(ctx, username)
+}
+
+// writerBackendName names the writer at index i in PG_WRITERS_CSV
+func writerBackendName(i int) string {
+	return fmt.Sprintf("writer%d", i)
+}
+
+// connectToBackend creates a connection to a backend database with TLS if
+// configured. The backend name (reader, writer0, writer1, ... or notify)
+// selects per-backend credentials and TLS settings; an empty name uses the
+// defaults.
+func (r *Router) connectToBackend(ctx context.Context, name, dsn string, opts *ConnectOptions) (*pgx.Conn, error) {
 	config, err := pgx.ParseConfig(dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse DSN: %w", err)
 	}
 
-	// Apply backend TLS configuration if enabled
-	if r.config.BackendTLS.Enabled {
-		config.TLSConfig = r.config.BackendTLS.TLS
+	if opts != nil && opts.OnNotice != nil {
+		config.OnNotice = opts.OnNotice
+	}
+
+	// Have the backend refuse writes of read-only users as well, including
+	// those the proxy can't see, like writes made by functions
+	if opts != nil && opts.ReadOnly {
+		config.RuntimeParams["default_transaction_read_only"] = "on"
 	}
 
+	// Log in as the session user's backend user
+	var login BackendLogin
+	if opts != nil && opts.Backend != nil {
+		login = opts.Backend.ForBackend(name)
+		if login.User != "" {
+			config.User = login.User
+			config.Password = login.Password
+		}
+	}
+
+	// Send a cancel request when the query context is cancelled, so the backend
+	// stops working on queries abandoned by the client
+	config.BuildContextWatcherHandler = func(pgConn *pgconn.PgConn) ctxwatch.Handler {
+		return &pgconn.CancelRequestContextWatcherHandler{
+			Conn:          pgConn,
+			DeadlineDelay: 5 * time.Second,
+		}
+	}
+
+	// Apply backend TLS configuration
+	r.config.BackendTLS.applyBackendTLS(config, name, dsn)
+
 	conn, err := pgx.ConnectConfig(ctx, config)
 	if err != nil {
 		return nil, err
 	}
 
+	// Switch to the session user's role, so grants and row-level security apply to them
+	if login.Role != "" {
+		if _, err := conn.PgConn().Exec(ctx, "SET ROLE "+pgx.Identifier{login.Role}.Sanitize()).ReadAll(); err != nil {
+			conn.Close(ctx)
+			return nil, fmt.Errorf("failed to set role %s: %w", login.Role, err)
+		}
+	}
+
 	return conn, nil
 }
 
 // ExecuteRead executes a read query on the reader backend
 func (r *Router) ExecuteRead(ctx context.Context, sql string) (pgx.Rows, error) {
-	conn, err := r.connectToBackend(ctx, r.config.ReaderDSN)
+	conn, err := r.connectToBackend(ctx, "reader", r.config.ReaderDSN, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to reader: %w", err)
 	}
@@ -79,14 +535,15 @@ func (r *Router) ExecuteRead(ctx context.Context, sql string) (pgx.Rows, error)
 }
 
 // ExecuteWrite executes a write query on all writer backends in a transaction
-func (r *Router) ExecuteWrite(ctx context.Context, sql string) error {
-	return r.ExecuteWriteWithParams(ctx, sql)
+func (r *Router) ExecuteWrite(ctx context.Context, sql string, opts *ConnectOptions) (*WriteResult, error) {
+	return r.ExecuteWriteWithParams(ctx, sql, opts)
 }
 
-// ExecuteWriteWithParams executes a write query with parameters on all writer backends in a transaction
-func (r *Router) ExecuteWriteWithParams(ctx context.Context, sql string, params ...interface{}) error {
+// ExecuteWriteWithParams executes a write query with parameters on all writer backends in a transaction.
+// The returned result is the one produced by the primary writer.
+func (r *Router) ExecuteWriteWithParams(ctx context.Context, sql string, opts *ConnectOptions, params ...interface{}) (*WriteResult, error) {
 	if len(r.config.WriterDSNs) == 0 {
-		return fmt.Errorf("no writer backends configured")
+		return nil, fmt.Errorf("no writer backends configured")
 	}
 
 	// Connect to all writers
@@ -108,11 +565,11 @@ func (r *Router) ExecuteWriteWithParams(ctx context.Context, sql string, params
 	}
 
 	// Connect to all writers
-	for _, dsn := range r.config.WriterDSNs {
-		conn, err := r.connectToBackend(ctx, dsn)
+	for i, dsn := range r.config.WriterDSNs {
+		conn, err := r.connectToBackend(ctx, writerBackendName(i), dsn, opts)
 		if err != nil {
 			cleanup()
-			return fmt.Errorf("failed to connect to writer %s: %w", dsn, err)
+			return nil, fmt.Errorf("failed to connect to writer %s: %w", dsn, err)
 		}
 		conns = append(conns, conn)
 	}
@@ -122,26 +579,59 @@ func (r *Router) ExecuteWriteWithParams(ctx context.Context, sql string, params
 		tx, err := conn.Begin(ctx)
 		if err != nil {
 			cleanup()
-			return fmt.Errorf("failed to begin transaction on writer %d: %w", i, err)
+			return nil, fmt.Errorf("failed to begin transaction on writer %d: %w", i, err)
 		}
 		txs = append(txs, tx)
 	}
 
 	// Execute query on all writers
+	returning := HasReturningClause(sql)
+	results := make([]*WriteResult, len(txs))
 	for i, tx := range txs {
-		_, err := tx.Exec(ctx, sql, params...)
+		var result *WriteResult
+		var err error
+		if returning {
+			result, err = queryWriteRows(ctx, tx, sql, params)
+		} else {
+			var tag pgconn.CommandTag
+			tag, err = tx.Exec(ctx, sql, params...)
+			result = &WriteResult{CommandTag: tag}
+		}
 		if err != nil {
 			cleanup()
-			return fmt.Errorf("failed to execute on writer %d: %w", i, err)
+			return nil, fmt.Errorf("failed to execute on writer %d: %w", i, err)
 		}
+		results[i] = result
 	}
 
-	// Commit all transactions
+	primary := results[r.config.PrimaryWriter]
+
+	// Make sure every writer produced the same rows as the primary
+	if returning && r.config.VerifyReturning {
+		for i, result := range results {
+			if i == r.config.PrimaryWriter {
+				continue
+			}
+			if err := compareWriteRows(primary, result); err != nil {
+				cleanup()
+				return nil, fmt.Errorf("writer %d diverged from primary writer %d: %w", i, r.config.PrimaryWriter, err)
+			}
+		}
+	}
+
+	// Commit all transactions. Once the first writer has committed, the
+	// others have to commit as well, so a statement timeout or a client
+	// cancel must not interrupt the commits.
+	commitCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), writerCommitTimeout)
+	defer cancel()
 	for i, tx := range txs {
-		err := tx.Commit(ctx)
+		err := tx.Commit(commitCtx)
 		if err != nil {
+			if i > 0 {
+				log.Printf("ERROR: writers diverged: committed on writers 0-%d but commit failed on writer %d, remaining writers rolled back: %v", i-1, i, err)
+			}
 			cleanup()
-			return fmt.Errorf("failed to commit on writer %d: %w", i, err)
+			return nil, fmt.Errorf("failed to commit on writer %d: %w", i, err)
 		}
 	}
 
@@ -150,5 +640,119 @@ func (r *Router) ExecuteWriteWithParams(ctx context.Context, sql string, params
 		conn.Close(ctx)
 	}
 
+	return primary, nil
+}
+
+// ExecuteNotify executes NOTIFY or pg_notify on the notify backend only, so
+// listeners receive each notification exactly once
+func (r *Router) ExecuteNotify(ctx context.Context, sql string, opts *ConnectOptions, params ...interface{}) (*WriteResult, error) {
+	conn, err := r.connectToBackend(ctx, r.config.NotifyBackend, r.config.NotifyDSN, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to notify backend: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	// NOTIFY returns no rows, while SELECT pg_notify(...) returns a single void column
+	if isNotifyStatement(sql) {
+		tag, err := conn.Exec(ctx, sql, params...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute notify: %w", err)
+		}
+		return &WriteResult{CommandTag: tag}, nil
+	}
+
+	result, err := queryWriteRows(ctx, conn, sql, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute notify: %w", err)
+	}
+	return result, nil
+}
+
+// DescribeWrite returns the columns a RETURNING write produces, as reported by the primary writer
+func (r *Router) DescribeWrite(ctx context.Context, sql string, opts *ConnectOptions) ([]pgconn.FieldDescription, error) {
+	conn, err := r.connectToBackend(ctx, writerBackendName(r.config.PrimaryWriter), r.config.WriterDSNs[r.config.PrimaryWriter], opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to primary writer: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	return describeQuery(ctx, conn, sql)
+}
+
+// DescribeNotify returns the columns of a pg_notify call, as reported by the
+// notify backend that executes it
+func (r *Router) DescribeNotify(ctx context.Context, sql string, opts *ConnectOptions) ([]pgconn.FieldDescription, error) {
+	conn, err := r.connectToBackend(ctx, r.config.NotifyBackend, r.config.NotifyDSN, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to notify backend: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	return describeQuery(ctx, conn, sql)
+}
+
+// describeQuery prepares a query unnamed to learn the columns it returns
+func describeQuery(ctx context.Context, conn *pgx.Conn, sql string) ([]pgconn.FieldDescription, error) {
+	sd, err := conn.Prepare(ctx, "", sql)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe query: %w", err)
+	}
+
+	return sd.Fields, nil
+}
+
+// queryer is implemented by both pgx.Conn and pgx.Tx
+type queryer interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// queryWriteRows runs a write that returns rows and collects them in text format
+func queryWriteRows(ctx context.Context, q queryer, sql string, params []interface{}) (*WriteResult, error) {
+	args := append([]interface{}{pgx.QueryResultFormats{pgx.TextFormatCode}}, params...)
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &WriteResult{Fields: rows.FieldDescriptions()}
+	for rows.Next() {
+		raw := rows.RawValues()
+		values := make([][]byte, len(raw))
+		for i, v := range raw {
+			if v != nil {
+				values[i] = append([]byte{}, v...)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result.CommandTag = rows.CommandTag()
+	return result, nil
+}
+
+// compareWriteRows checks that two writers returned identical rows
+func compareWriteRows(expected, actual *WriteResult) error {
+	if len(expected.Rows) != len(actual.Rows) {
+		return fmt.Errorf("returned %d rows, expected %d", len(actual.Rows), len(expected.Rows))
+	}
+
+	for i := range expected.Rows {
+		if len(expected.Rows[i]) != len(actual.Rows[i]) {
+			return fmt.Errorf("row %d has %d columns, expected %d", i, len(actual.Rows[i]), len(expected.Rows[i]))
+		}
+		for j := range expected.Rows[i] {
+			if (expected.Rows[i][j] == nil) != (actual.Rows[i][j] == nil) ||
+				string(expected.Rows[i][j]) != string(actual.Rows[i][j]) {
+				return fmt.Errorf("row %d column %d differs", i, j)
+			}
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,110 @@
+package main
+
+import "testing"
+
+func TestHasReturningClause(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want bool
+	}{
+		{"INSERT INTO t (a) VALUES (1) RETURNING id", true},
+		{"update t set a = 1 returning *", true},
+		{"DELETE FROM t WHERE id = 1\nRETURNING id, a", true},
+		{"UPDATE t SET note = 'x' WHERE note = 'returning'", false},
+		{"UPDATE t SET note = 'it''s returning' WHERE id = 1", false},
+		{`UPDATE t SET note = E'\' returning' WHERE id = 1`, false},
+		{"UPDATE t SET note = $$returning$$ WHERE id = 1", false},
+		{"UPDATE t SET note = $body$ returning $body$ WHERE id = 1", false},
+		{`UPDATE t SET "returning" = 1`, false},
+		{"UPDATE t SET a = 1 -- returning id", false},
+		{"UPDATE t SET a = 1 /* returning /* nested */ id */", false},
+		{"UPDATE t SET a = $1 WHERE b = 'x' RETURNING a", true},
+		{"INSERT INTO returning_log (a) VALUES (1)", false},
+	}
+
+	for _, tt := range tests {
+		if got := HasReturningClause(tt.sql); got != tt.want {
+			t.Errorf("HasReturningClause(%q) = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyQuery(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want QueryType
+	}{
+		{"SELECT * FROM t", QueryTypeRead},
+		{"INSERT INTO t VALUES (1)", QueryTypeWrite},
+		{"LISTEN chan", QueryTypeListen},
+		{"UNLISTEN *", QueryTypeUnlisten},
+		{"/* app */ SELECT 1", QueryTypeRead},
+		{"-- c\n/* x */ LISTEN chan", QueryTypeListen},
+		{"/* SELECT */ DELETE FROM t", QueryTypeWrite},
+		{"NOTIFY chan", QueryTypeNotify},
+		{"NOTIFY chan, 'a; b';", QueryTypeNotify},
+		{"SELECT pg_notify('chan', 'payload')", QueryTypeNotify},
+		{"select pg_notify($1, $2);", QueryTypeNotify},
+		{"SELECT pg_notify('chan', 'x) FROM t')", QueryTypeNotify},
+		{"SELECT pg_notify('chan', (SELECT max(id)::text FROM t))", QueryTypeNotify},
+		{"SELECT pg_notify('chan', id::text) FROM t", QueryTypeWrite},
+		{"SELECT pg_notify('a', 'x'), pg_notify('b', 'y')", QueryTypeWrite},
+		{"INSERT INTO t VALUES (1) RETURNING pg_notify('chan', 'x')", QueryTypeWrite},
+		{"WITH x AS (UPDATE t SET a = 1 RETURNING id) SELECT pg_notify('chan', 'x')", QueryTypeWrite},
+		{"UPDATE t SET a = 1; SELECT pg_notify('chan', 'x')", QueryTypeWrite},
+		{"NOTIFY chan; DELETE FROM t", QueryTypeWrite},
+		{"SELECT 'pg_notify(' FROM t", QueryTypeRead},
+		{"DECLARE c CURSOR FOR SELECT 1", QueryTypeCursor},
+		{"FETCH 10 FROM c", QueryTypeCursor},
+		{"EXPLAIN SELECT 1", QueryTypeRead},
+		{"EXPLAIN DELETE FROM t", QueryTypeRead},
+		{"EXPLAIN ANALYZE SELECT 1", QueryTypeRead},
+		{"EXPLAIN ANALYZE DELETE FROM t", QueryTypeWrite},
+		{"explain analyse verbose update t set a = 1", QueryTypeWrite},
+		{"EXPLAIN (ANALYZE, FORMAT JSON) INSERT INTO t VALUES (1)", QueryTypeWrite},
+		{"EXPLAIN (ANALYZE false) DELETE FROM t", QueryTypeRead},
+		{"EXPLAIN (FORMAT JSON) DELETE FROM t", QueryTypeRead},
+	}
+
+	for _, tt := range tests {
+		if got := ClassifyQuery(tt.sql); got != tt.want {
+			t.Errorf("ClassifyQuery(%q) = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	got := splitStatements("SELECT 'a;b'; UPDATE t SET a = 1 -- ;\n; /* ; */ ;")
+	want := []string{"SELECT 'a;b'", "UPDATE t SET a = 1 -- ;"}
+	if len(got) != len(want) {
+		t.Fatalf("splitStatements() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("statement %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMixesListen(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want bool
+	}{
+		{"LISTEN a", false},
+		{"LISTEN a;", false},
+		{"LISTEN a; LISTEN b", false},
+		{"LISTEN a; UNLISTEN b;", false},
+		{"LISTEN a; INSERT INTO t VALUES (1)", true},
+		{"LISTEN a; SELECT 1", true},
+		{"SELECT 1; UNLISTEN *", true},
+		{"SELECT 'LISTEN a'; SELECT 1", false},
+		{"INSERT INTO t VALUES (1); SELECT 1", false},
+	}
+
+	for _, tt := range tests {
+		if got := mixesListen(tt.sql); got != tt.want {
+			t.Errorf("mixesListen(%q) = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrSCRAMMalformed is returned for SCRAM messages that don't follow RFC 5802
+var ErrSCRAMMalformed = errors.New("malformed SCRAM message")
+
+// scramClientFirst is a parsed client-first-message (RFC 5802 section 7):
+//
+//	gs2-header = gs2-cbind-flag "," [ authzid ] ","
+//	client-first-message-bare = [reserved-mext ","] username "," nonce ["," extensions]
+type scramClientFirst struct {
+	cbindFlag string // "n", "y" or "p"
+	cbName    string // Channel binding type when cbindFlag is "p"
+	authzid   string // Authorization identity, empty if not given
+	gs2Header string // GS2 header including the trailing comma
+	bare      string // client-first-message-bare
+	username  string // Unescaped username
+	nonce     string // Client nonce
+}
+
+// scramClientFinal is a parsed client-final-message:
+//
+//	channel-binding "," nonce ["," extensions] "," proof
+type scramClientFinal struct {
+	channelBinding []byte // Decoded c= attribute
+	nonce          string // Client and server nonce
+	proof          []byte // Decoded p= attribute
+	withoutProof   string // client-final-message-without-proof
+}
+
+// parseSCRAMClientFirst strictly parses a client-first-message. PostgreSQL
+// clients send an empty username and use the startup user, so an empty
+// username is accepted.
+func parseSCRAMClientFirst(msg string) (*scramClientFirst, error) {
+	first := &scramClientFirst{}
+
+	// gs2-cbind-flag
+	flag, rest, ok := strings.Cut(msg, ",")
+	if !ok {
+		return nil, fmt.Errorf("missing GS2 header")
+	}
+	switch {
+	case flag == "n" || flag == "y":
+		first.cbindFlag = flag
+	case strings.HasPrefix(flag, "p="):
+		first.cbindFlag = "p"
+		first.cbName = flag[2:]
+		if !isSCRAMCBName(first.cbName) {
+			return nil, fmt.Errorf("invalid channel binding name: %q", first.cbName)
+		}
+	default:
+		return nil, fmt.Errorf("invalid channel binding flag: %q", flag)
+	}
+
+	// [ authzid ]
+	authzid, bare, ok := strings.Cut(rest, ",")
+	if !ok {
+		return nil, fmt.Errorf("missing GS2 header")
+	}
+	if authzid != "" {
+		if !strings.HasPrefix(authzid, "a=") {
+			return nil, fmt.Errorf("invalid authorization identity: %q", authzid)
+		}
+		name, err := unescapeSCRAMName(authzid[2:])
+		if err != nil || name == "" {
+			return nil, fmt.Errorf("invalid authorization identity: %q", authzid)
+		}
+		first.authzid = name
+	}
+	first.gs2Header = msg[:len(msg)-len(bare)]
+	first.bare = bare
+
+	attrs := strings.Split(bare, ",")
+	if strings.HasPrefix(attrs[0], "m=") {
+		return nil, fmt.Errorf("unsupported mandatory extension")
+	}
+	if len(attrs) < 2 {
+		return nil, fmt.Errorf("missing username or nonce")
+	}
+
+	// username
+	if !strings.HasPrefix(attrs[0], "n=") {
+		return nil, fmt.Errorf("expected username attribute, got %q", attrs[0])
+	}
+	username, err := unescapeSCRAMName(attrs[0][2:])
+	if err != nil {
+		return nil, err
+	}
+	first.username = username
+
+	// nonce
+	if !strings.HasPrefix(attrs[1], "r=") {
+		return nil, fmt.Errorf("expected nonce attribute, got %q", attrs[1])
+	}
+	first.nonce = attrs[1][2:]
+	if first.nonce == "" || !isSCRAMPrintable(first.nonce) {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+
+	// ["," extensions]
+	for _, attr := range attrs[2:] {
+		if !isSCRAMAttrVal(attr) {
+			return nil, fmt.Errorf("invalid extension attribute: %q", attr)
+		}
+	}
+
+	return first, nil
+}
+
+// parseSCRAMClientFinal strictly parses a client-final-message
+func parseSCRAMClientFinal(msg string) (*scramClientFinal, error) {
+	final := &scramClientFinal{}
+
+	attrs := strings.Split(msg, ",")
+	if len(attrs) < 3 {
+		return nil, fmt.Errorf("missing channel binding, nonce or proof")
+	}
+
+	// channel-binding
+	if !strings.HasPrefix(attrs[0], "c=") {
+		return nil, fmt.Errorf("expected channel binding attribute, got %q", attrs[0])
+	}
+	channelBinding, err := base64.StdEncoding.Strict().DecodeString(attrs[0][2:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid channel binding encoding: %w", err)
+	}
+	final.channelBinding = channelBinding
+
+	// nonce
+	if !strings.HasPrefix(attrs[1], "r=") {
+		return nil, fmt.Errorf("expected nonce attribute, got %q", attrs[1])
+	}
+	final.nonce = attrs[1][2:]
+	if final.nonce == "" || !isSCRAMPrintable(final.nonce) {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+
+	// ["," extensions]
+	last := len(attrs) - 1
+	for _, attr := range attrs[2:last] {
+		if !isSCRAMAttrVal(attr) || strings.HasPrefix(attr, "p=") {
+			return nil, fmt.Errorf("invalid extension attribute: %q", attr)
+		}
+	}
+
+	// proof
+	if !strings.HasPrefix(attrs[last], "p=") {
+		return nil, fmt.Errorf("expected proof attribute, got %q", attrs[last])
+	}
+	proof, err := base64.StdEncoding.Strict().DecodeString(attrs[last][2:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid proof encoding: %w", err)
+	}
+	if len(proof) != sha256.Size {
+		return nil, fmt.Errorf("invalid proof length: %d", len(proof))
+	}
+	final.proof = proof
+	final.withoutProof = msg[:len(msg)-len(attrs[last])-1]
+
+	return final, nil
+}
+
+// unescapeSCRAMName decodes a saslname, in which "," and "=" must be
+// escaped as "=2C" and "=3D"
+func unescapeSCRAMName(name string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case ',':
+			return "", fmt.Errorf("unescaped comma in name")
+		case '=':
+			switch {
+			case strings.HasPrefix(name[i:], "=2C"):
+				b.WriteByte(',')
+			case strings.HasPrefix(name[i:], "=3D"):
+				b.WriteByte('=')
+			default:
+				return "", fmt.Errorf("invalid escape sequence in name")
+			}
+			i += 2
+		case 0:
+			return "", fmt.Errorf("NUL character in name")
+		default:
+			b.WriteByte(name[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// isSCRAMPrintable reports whether s consists of printable ASCII characters except ","
+func isSCRAMPrintable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7E || s[i] == ',' {
+			return false
+		}
+	}
+	return true
+}
+
+// isSCRAMCBName reports whether s is a valid channel binding type name
+func isSCRAMCBName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// isSCRAMAttrVal reports whether s is an attribute of the form ALPHA "=" value
+func isSCRAMAttrVal(s string) bool {
+	if len(s) < 2 || s[1] != '=' {
+		return false
+	}
+	c := s[0]
+	if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+		return false
+	}
+	for i := 2; i < len(s); i++ {
+		if s[i] == 0 || s[i] == ',' {
+			return false
+		}
+	}
+	return true
+}
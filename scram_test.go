@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func FuzzParseSCRAMClientFirst(f *testing.F) {
+	for _, seed := range []string{
+		"n,,n=,r=rOprNGfwEbeRWgbNEkqO",
+		"n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL",
+		"y,,n=,r=abc,x=ext",
+		"p=tls-server-end-point,,n=,r=abc",
+		"n,a=admin,n=us=2Cer=3D,r=abc",
+		"n,,m=ext,n=user,r=abc",
+		"n,,n=user",
+		"n,,n=a=2X,r=abc",
+		"p=,,n=,r=abc",
+		"",
+		",,,",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, msg string) {
+		first, err := parseSCRAMClientFirst(msg)
+		if err != nil {
+			return
+		}
+
+		if first.gs2Header+first.bare != msg {
+			t.Errorf("GS2 header %q and bare message %q don't make up %q", first.gs2Header, first.bare, msg)
+		}
+		if !strings.HasSuffix(first.gs2Header, ",") {
+			t.Errorf("GS2 header %q doesn't end with a comma", first.gs2Header)
+		}
+		if first.nonce == "" || !isSCRAMPrintable(first.nonce) {
+			t.Errorf("accepted invalid nonce %q", first.nonce)
+		}
+		switch first.cbindFlag {
+		case "n", "y":
+			if first.cbName != "" {
+				t.Errorf("channel binding name %q without the p flag", first.cbName)
+			}
+		case "p":
+			if !isSCRAMCBName(first.cbName) {
+				t.Errorf("accepted invalid channel binding name %q", first.cbName)
+			}
+		default:
+			t.Errorf("accepted invalid channel binding flag %q", first.cbindFlag)
+		}
+		if strings.ContainsRune(first.username, 0) {
+			t.Errorf("accepted NUL in username %q", first.username)
+		}
+
+		// The username must survive escaping and parsing again
+		escaped := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(first.username)
+		again, err := parseSCRAMClientFirst("n,,n=" + escaped + ",r=" + first.nonce)
+		if err != nil || again.username != first.username {
+			t.Errorf("username %q doesn't round-trip: %v", first.username, err)
+		}
+	})
+}
+
+func FuzzParseSCRAMClientFinal(f *testing.F) {
+	proof := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	for _, seed := range []string{
+		"c=biws,r=abcdef,p=" + proof,
+		"c=biws,r=abcdef,x=ext,p=" + proof,
+		"c=eSws,r=abcdef,p=" + proof,
+		"c=biws,r=abcdef,p=" + proof[:len(proof)-4],
+		"c=biws,r=abcdef,p=" + proof + "=",
+		"c=biws,p=" + proof + ",r=abcdef",
+		"c=biws,r=abc,p=x,p=" + proof,
+		"c=b!ws,r=abc,p=" + proof,
+		"",
+		",,",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, msg string) {
+		final, err := parseSCRAMClientFinal(msg)
+		if err != nil {
+			return
+		}
+
+		if len(final.proof) != 32 {
+			t.Errorf("accepted proof of %d bytes", len(final.proof))
+		}
+		if final.nonce == "" || !isSCRAMPrintable(final.nonce) {
+			t.Errorf("accepted invalid nonce %q", final.nonce)
+		}
+		if rebuilt := final.withoutProof + ",p=" + base64.StdEncoding.EncodeToString(final.proof); rebuilt != msg {
+			t.Errorf("message without proof %q and proof don't make up %q", final.withoutProof, msg)
+		}
+		if !strings.HasPrefix(final.withoutProof, "c="+base64.StdEncoding.EncodeToString(final.channelBinding)+",") {
+			t.Errorf("channel binding %q doesn't match %q", final.channelBinding, msg)
+		}
+	})
+}
+
+func TestParseSCRAMClientFirst(t *testing.T) {
+	tests := []struct {
+		msg      string
+		username string
+		ok       bool
+	}{
+		{"n,,n=,r=abc", "", true},
+		{"n,,n=user,r=abc", "user", true},
+		{"n,,n=a=2Cb=3Dc,r=abc", "a,b=c", true},
+		{"p=tls-server-end-point,,n=,r=abc", "", true},
+		{"n,a=admin,n=user,r=abc,x=ext", "user", true},
+		{"n,,n=a=2Xb,r=abc", "", false},
+		{"n,,n=user,r=", "", false},
+		{"n,,n=user,r=a b", "", false},
+		{"n,,m=ext,n=user,r=abc", "", false},
+		{"x,,n=user,r=abc", "", false},
+		{"p=,,n=user,r=abc", "", false},
+		{"n,b=admin,n=user,r=abc", "", false},
+		{"n,,r=abc,n=user", "", false},
+		{"n,,n=user,r=abc,1=x", "", false},
+		{"n,,n=user", "", false},
+	}
+
+	for _, tt := range tests {
+		first, err := parseSCRAMClientFirst(tt.msg)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseSCRAMClientFirst(%q) error = %v, want ok %v", tt.msg, err, tt.ok)
+			continue
+		}
+		if err == nil && first.username != tt.username {
+			t.Errorf("parseSCRAMClientFirst(%q) username = %q, want %q", tt.msg, first.username, tt.username)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AWSCredentials are the keys used to sign AWS requests
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time // Zero for long-lived keys
+}
+
+// signAWSRequest signs a request with AWS Signature Version 4. The body must
+// be the request's full payload.
+func signAWSRequest(req *http.Request, body []byte, creds *AWSCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	// Canonical headers: host plus every header set on the request
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 computes HMAC-SHA256 of data
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// AWSCredentialChain resolves AWS credentials like the SDKs do: from the
+// environment, then the shared credentials file, then the EC2 instance
+// metadata service (IMDSv2)
+type AWSCredentialChain struct {
+	IMDSEndpoint string       // Instance metadata endpoint, e.g. http://169.254.169.254
+	HTTPClient   *http.Client // Client for IMDS requests
+
+	mu   sync.Mutex
+	imds *AWSCredentials // Cached instance role credentials
+}
+
+// Retrieve returns the first available credentials
+func (c *AWSCredentialChain) Retrieve(ctx context.Context) (*AWSCredentials, error) {
+	if creds := awsEnvCredentials(); creds != nil {
+		return creds, nil
+	}
+
+	creds, err := awsSharedCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		return creds, nil
+	}
+
+	return c.instanceCredentials(ctx)
+}
+
+// awsEnvCredentials reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+func awsEnvCredentials() *AWSCredentials {
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil
+	}
+	return &AWSCredentials{
+		AccessKeyID:     accessKey,
+		SecretAccessKey: secretKey,
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// awsSharedCredentials reads the AWS_PROFILE profile (default: default) from
+// AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials. It returns nil if the
+// file or profile doesn't exist.
+func awsSharedCredentials() (*AWSCredentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open AWS credentials file: %w", err)
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	inProfile := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inProfile = strings.TrimSpace(line[1:len(line)-1]) == profile
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && inProfile {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read AWS credentials file: %w", err)
+	}
+
+	if values["aws_access_key_id"] == "" || values["aws_secret_access_key"] == "" {
+		return nil, nil
+	}
+	return &AWSCredentials{
+		AccessKeyID:     values["aws_access_key_id"],
+		SecretAccessKey: values["aws_secret_access_key"],
+		SessionToken:    values["aws_session_token"],
+	}, nil
+}
+
+// instanceCredentials fetches the instance role's credentials from IMDSv2,
+// reusing them until five minutes before they expire
+func (c *AWSCredentialChain) instanceCredentials(ctx context.Context) (*AWSCredentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.imds != nil && time.Until(c.imds.Expires) > 5*time.Minute {
+		return c.imds, nil
+	}
+
+	// IMDSv2 requires a session token for every metadata request
+	token, err := c.imdsRequest(ctx, http.MethodPut, "/latest/api/token", "")
+	if err != nil {
+		return nil, fmt.Errorf("no AWS credentials found in environment, credentials file or instance metadata: %w", err)
+	}
+
+	role, err := c.imdsRequest(ctx, http.MethodGet, "/latest/meta-data/iam/security-credentials/", token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance role: %w", err)
+	}
+	role = strings.TrimSpace(strings.SplitN(role, "\n", 2)[0])
+
+	data, err := c.imdsRequest(ctx, http.MethodGet, "/latest/meta-data/iam/security-credentials/"+role, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance role credentials: %w", err)
+	}
+
+	var resp struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal([]byte(data), &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse instance role credentials: %w", err)
+	}
+
+	c.imds = &AWSCredentials{
+		AccessKeyID:     resp.AccessKeyID,
+		SecretAccessKey: resp.SecretAccessKey,
+		SessionToken:    resp.Token,
+		Expires:         resp.Expiration,
+	}
+	return c.imds, nil
+}
+
+// imdsRequest sends a request to the instance metadata service
+func (c *AWSCredentialChain) imdsRequest(ctx context.Context, method, path, token string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.IMDSEndpoint, "/")+path, nil)
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	} else {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("instance metadata returned status %d", resp.StatusCode)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Vectors from the AWS Signature Version 4 test suite
+func TestSignAWSRequest(t *testing.T) {
+	creds := &AWSCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		want   string
+	}{
+		{
+			"get-vanilla", http.MethodGet, "https://example.amazonaws.com/",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			"post-vanilla", http.MethodPost, "https://example.amazonaws.com/",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			"get-vanilla-query-order-key-case", http.MethodGet, "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signAWSRequest(req, nil, creds, "us-east-1", "service", now)
+
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %q, want 20150830T123600Z", got)
+			}
+		})
+	}
+}
+
+// fakeAWS serves IMDSv2 and the Secrets Manager GetSecretValue API
+type fakeAWS struct {
+	mu        sync.Mutex
+	versionID string
+	secret    string
+	imdsCalls int
+}
+
+func (a *fakeAWS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/latest/api/token":
+		if r.Method != http.MethodPut || r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		a.imdsCalls++
+		w.Write([]byte("imds-token"))
+	case strings.HasPrefix(r.URL.Path, "/latest/meta-data/"):
+		// IMDSv1 requests without a session token are refused
+		if r.Header.Get("X-aws-ec2-metadata-token") != "imds-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/latest/meta-data/iam/security-credentials/":
+			w.Write([]byte("pprox-role\n"))
+		case "/latest/meta-data/iam/security-credentials/pprox-role":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"AccessKeyId":     "ASIAEXAMPLE",
+				"SecretAccessKey": "role-secret",
+				"Token":           "role-session-token",
+				"Expiration":      time.Now().Add(time.Hour),
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.URL.Path == "/" && r.Method == http.MethodPost:
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" ||
+			r.Header.Get("X-Amz-Security-Token") != "role-session-token" ||
+			!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=ASIAEXAMPLE/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var input map[string]string
+		json.NewDecoder(r.Body).Decode(&input)
+		json.NewEncoder(w).Encode(awsSecretValue{Name: input["SecretId"], VersionID: a.versionID, SecretString: a.secret})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestAWSSecretsProviderWithInstanceRole(t *testing.T) {
+	// Leave only the instance metadata service in the credential chain
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	aws := &fakeAWS{versionID: "v1", secret: `{"alice": "alice-password"}`}
+	server := httptest.NewServer(aws)
+	defer server.Close()
+
+	provider := NewAWSSecretsProvider(&AWSSecretsConfig{
+		SecretID:     "pprox/users",
+		Region:       "us-east-1",
+		Endpoint:     server.URL,
+		IMDSEndpoint: server.URL,
+	})
+	ctx := context.Background()
+
+	credentials, err := provider.GetCredentials(ctx)
+	if err != nil {
+		t.Fatalf("GetCredentials() error = %v", err)
+	}
+	if alice := credentials["alice"]; alice == nil || alice.Password != "alice-password" {
+		t.Fatalf("alice = %+v, want password alice-password", alice)
+	}
+
+	// The same VersionId is reloaded until it is applied, then skipped, and
+	// role credentials are cached
+	if _, err := provider.GetCredentials(ctx); err != nil {
+		t.Fatalf("GetCredentials() for an uncommitted version error = %v", err)
+	}
+	provider.CommitCredentials()
+	if _, err := provider.GetCredentials(ctx); !errors.Is(err, ErrCredentialsNotModified) {
+		t.Fatalf("GetCredentials() for the same version error = %v, want ErrCredentialsNotModified", err)
+	}
+	if aws.imdsCalls != 1 {
+		t.Errorf("requested %d IMDS tokens, want 1", aws.imdsCalls)
+	}
+
+	aws.mu.Lock()
+	aws.versionID = "v2"
+	aws.secret = `{"users": [{"username": "bob", "password": "bob-password", "read_only": true}]}`
+	aws.mu.Unlock()
+
+	credentials, err = provider.GetCredentials(ctx)
+	if err != nil {
+		t.Fatalf("GetCredentials() for a new version error = %v", err)
+	}
+	if bob := credentials["bob"]; bob == nil || !bob.Attributes.ReadOnly || len(credentials) != 1 {
+		t.Errorf("credentials for version v2 = %v, want only read-only bob", credentials)
+	}
+}
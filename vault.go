@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Vault authentication methods
+const (
+	VaultAuthToken      = "token"
+	VaultAuthAppRole    = "approle"
+	VaultAuthKubernetes = "kubernetes"
+)
+
+// VaultConfig configures the Vault credential provider
+type VaultConfig struct {
+	Address    string // e.g. https://vault.example.com:8200
+	Namespace  string // Vault Enterprise namespace, sent as X-Vault-Namespace
+	SecretPath string // KV v2 secret, e.g. secret/data/pprox/users or secret/pprox/users
+	Version    int    // Pinned secret version, 0 for the latest
+
+	AuthMethod string // token, approle or kubernetes
+	AuthMount  string // Mount path of the auth method, defaults to its name
+	Token      string // Token for token auth
+
+	RoleID   string // AppRole role ID
+	SecretID string // AppRole secret ID
+
+	K8sRole      string // Vault role for Kubernetes auth
+	K8sTokenFile string // Service account token presented to Vault
+
+	HTTPClient *http.Client // Client for Vault requests, nil for a default one
+}
+
+// VaultCredentialProvider loads credentials from a HashiCorp Vault KV v2 secret.
+// Each key of the secret is a username; its value is either a password or
+// verifier (with optional ";attributes" as in PG_USERS), or an object with a
+// password field and the fields of UserAttributes.
+type VaultCredentialProvider struct {
+	config *VaultConfig
+	client *http.Client
+	mount  string // KV v2 mount
+	path   string // Secret path within the mount
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time // Zero for tokens that don't expire
+	tokenTTL    time.Duration
+	renewable   bool
+	lastVersion int // Secret version of the last applied load, 0 before the first
+	nextVersion int // Secret version of the last load, recorded once it is applied
+}
+
+// vaultError is an error response from Vault
+type vaultError struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *vaultError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vault returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("vault returned status %d: %s", e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+// vaultAuth is the auth block of Vault login and renewal responses
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+func NewVaultCredentialProvider(config *VaultConfig) *VaultCredentialProvider {
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	// Accept both the API path (secret/data/...) and the logical path (secret/...)
+	secretPath := strings.Trim(config.SecretPath, "/")
+	mount, path, _ := strings.Cut(secretPath, "/")
+	if m, p, ok := strings.Cut(secretPath, "/data/"); ok {
+		mount, path = m, p
+	}
+
+	return &VaultCredentialProvider{
+		config: config,
+		client: client,
+		mount:  mount,
+		path:   path,
+	}
+}
+
+func (p *VaultCredentialProvider) GetCredentials(ctx context.Context) (map[string]*UserEntry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// A failed load must not commit the version of an earlier one
+	p.nextVersion = p.lastVersion
+
+	if err := p.ensureToken(ctx); err != nil {
+		return nil, err
+	}
+
+	secret, err := p.readSecret(ctx)
+
+	// A revoked or expired login token is replaced once
+	if vErr, ok := err.(*vaultError); ok && vErr.StatusCode == http.StatusForbidden && p.config.AuthMethod != VaultAuthToken {
+		if err := p.login(ctx); err != nil {
+			return nil, err
+		}
+		secret, err = p.readSecret(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Vault secret: %w", err)
+	}
+
+	if secret.Data == nil {
+		return nil, fmt.Errorf("vault secret version %d has been deleted or destroyed", secret.Metadata.Version)
+	}
+
+	// Only rebuild credentials when the secret has a new version
+	if p.lastVersion != 0 && secret.Metadata.Version == p.lastVersion {
+		return nil, ErrCredentialsNotModified
+	}
+
+	credentials := make(map[string]*UserEntry)
+	for username, raw := range secret.Data {
+		entry, err := parseUserValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Vault entry for user %s: %w", username, err)
+		}
+		credentials[username] = entry
+	}
+
+	p.nextVersion = secret.Metadata.Version
+	return credentials, nil
+}
+
+// CommitCredentials records the secret version of the last load once the
+// manager has validated and applied its credentials, so a rejected version
+// is loaded again instead of being skipped as unchanged
+func (p *VaultCredentialProvider) CommitCredentials() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastVersion = p.nextVersion
+}
+
+func (p *VaultCredentialProvider) SupportsReload() bool {
+	return true
+}
+
+// vaultSecret is the data of a KV v2 read response
+type vaultSecret struct {
+	Data     map[string]json.RawMessage `json:"data"`
+	Metadata struct {
+		Version int `json:"version"`
+	} `json:"metadata"`
+}
+
+// readSecret reads the configured version of the KV v2 secret
+func (p *VaultCredentialProvider) readSecret(ctx context.Context) (*vaultSecret, error) {
+	path := "/v1/" + p.mount + "/data/" + p.path
+	if p.config.Version > 0 {
+		path += fmt.Sprintf("?version=%d", p.config.Version)
+	}
+
+	var resp struct {
+		Data vaultSecret `json:"data"`
+	}
+	if err := p.request(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// ensureToken obtains a token on first use and renews it once two thirds of its TTL have passed
+func (p *VaultCredentialProvider) ensureToken(ctx context.Context) error {
+	if p.token == "" {
+		if p.config.AuthMethod != VaultAuthToken {
+			return p.login(ctx)
+		}
+		p.token = p.config.Token
+		return p.lookupToken(ctx)
+	}
+
+	if p.tokenExpiry.IsZero() || time.Until(p.tokenExpiry) > p.tokenTTL/3 {
+		return nil
+	}
+
+	if p.renewable {
+		err := p.renewToken(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Failed to renew Vault token: %v", err)
+	}
+
+	if p.config.AuthMethod != VaultAuthToken {
+		return p.login(ctx)
+	}
+	return nil
+}
+
+// login authenticates with AppRole or Kubernetes auth
+func (p *VaultCredentialProvider) login(ctx context.Context) error {
+	mount := p.config.AuthMount
+	if mount == "" {
+		mount = p.config.AuthMethod
+	}
+
+	var body map[string]string
+	switch p.config.AuthMethod {
+	case VaultAuthAppRole:
+		body = map[string]string{"role_id": p.config.RoleID, "secret_id": p.config.SecretID}
+	case VaultAuthKubernetes:
+		jwt, err := os.ReadFile(p.config.K8sTokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read Kubernetes service account token: %w", err)
+		}
+		body = map[string]string{"role": p.config.K8sRole, "jwt": strings.TrimSpace(string(jwt))}
+	default:
+		return fmt.Errorf("unsupported Vault auth method: %s", p.config.AuthMethod)
+	}
+
+	p.token = ""
+	var resp struct {
+		Auth vaultAuth `json:"auth"`
+	}
+	if err := p.request(ctx, http.MethodPost, "/v1/auth/"+mount+"/login", body, &resp); err != nil {
+		return fmt.Errorf("vault %s login failed: %w", p.config.AuthMethod, err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return fmt.Errorf("vault %s login returned no token", p.config.AuthMethod)
+	}
+
+	p.token = resp.Auth.ClientToken
+	p.setLease(resp.Auth.LeaseDuration, resp.Auth.Renewable)
+	return nil
+}
+
+// lookupToken reads the TTL of a configured token, so it can be renewed in time
+func (p *VaultCredentialProvider) lookupToken(ctx context.Context) error {
+	var resp struct {
+		Data struct {
+			TTL       int  `json:"ttl"`
+			Renewable bool `json:"renewable"`
+		} `json:"data"`
+	}
+	if err := p.request(ctx, http.MethodGet, "/v1/auth/token/lookup-self", nil, &resp); err != nil {
+		return fmt.Errorf("vault token lookup failed: %w", err)
+	}
+
+	p.setLease(resp.Data.TTL, resp.Data.Renewable)
+	return nil
+}
+
+// renewToken extends the lease of the current token
+func (p *VaultCredentialProvider) renewToken(ctx context.Context) error {
+	var resp struct {
+		Auth vaultAuth `json:"auth"`
+	}
+	if err := p.request(ctx, http.MethodPost, "/v1/auth/token/renew-self", map[string]string{}, &resp); err != nil {
+		return err
+	}
+
+	p.setLease(resp.Auth.LeaseDuration, resp.Auth.Renewable)
+	return nil
+}
+
+// setLease records the lifetime of the current token; a TTL of 0 never expires
+func (p *VaultCredentialProvider) setLease(ttlSeconds int, renewable bool) {
+	p.tokenTTL = time.Duration(ttlSeconds) * time.Second
+	p.renewable = renewable
+	p.tokenExpiry = time.Time{}
+	if ttlSeconds > 0 {
+		p.tokenExpiry = time.Now().Add(p.tokenTTL)
+	}
+}
+
+// request sends a request to the Vault API and decodes the JSON response into out
+func (p *VaultCredentialProvider) request(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(p.config.Address, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	if p.token != "" {
+		req.Header.Set("X-Vault-Token", p.token)
+	}
+	if p.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.config.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		vErr := &vaultError{StatusCode: resp.StatusCode}
+		json.Unmarshal(data, vErr)
+		return vErr
+	}
+
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("failed to decode Vault response: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadVaultConfig loads Vault provider settings from environment
+func loadVaultConfig() (*VaultConfig, error) {
+	config := &VaultConfig{
+		Address:      os.Getenv("VAULT_ADDR"),
+		Namespace:    os.Getenv("VAULT_NAMESPACE"),
+		SecretPath:   os.Getenv("VAULT_SECRET_PATH"),
+		AuthMethod:   os.Getenv("VAULT_AUTH_METHOD"),
+		AuthMount:    os.Getenv("VAULT_AUTH_MOUNT"),
+		Token:        os.Getenv("VAULT_TOKEN"),
+		RoleID:       os.Getenv("VAULT_ROLE_ID"),
+		SecretID:     os.Getenv("VAULT_SECRET_ID"),
+		K8sRole:      os.Getenv("VAULT_K8S_ROLE"),
+		K8sTokenFile: os.Getenv("VAULT_K8S_TOKEN_FILE"),
+	}
+
+	if config.Address == "" || config.SecretPath == "" {
+		return nil, fmt.Errorf("VAULT_ADDR and VAULT_SECRET_PATH must be set")
+	}
+	if _, err := url.Parse(config.Address); err != nil {
+		return nil, fmt.Errorf("invalid VAULT_ADDR: %w", err)
+	}
+
+	if v := os.Getenv("VAULT_SECRET_VERSION"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil || version < 0 {
+			return nil, fmt.Errorf("invalid VAULT_SECRET_VERSION: %s", v)
+		}
+		config.Version = version
+	}
+
+	if config.AuthMethod == "" {
+		config.AuthMethod = VaultAuthToken
+	}
+	switch config.AuthMethod {
+	case VaultAuthToken:
+		if config.Token == "" {
+			return nil, fmt.Errorf("VAULT_TOKEN must be set for token auth")
+		}
+	case VaultAuthAppRole:
+		if file := os.Getenv("VAULT_SECRET_ID_FILE"); file != "" {
+			secretID, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read VAULT_SECRET_ID_FILE: %w", err)
+			}
+			config.SecretID = strings.TrimSpace(string(secretID))
+		}
+		if config.RoleID == "" || config.SecretID == "" {
+			return nil, fmt.Errorf("VAULT_ROLE_ID and VAULT_SECRET_ID must be set for AppRole auth")
+		}
+	case VaultAuthKubernetes:
+		if config.K8sRole == "" {
+			return nil, fmt.Errorf("VAULT_K8S_ROLE must be set for Kubernetes auth")
+		}
+		if config.K8sTokenFile == "" {
+			config.K8sTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+		}
+	default:
+		return nil, fmt.Errorf("invalid VAULT_AUTH_METHOD: %s (must be: token, approle, kubernetes)", config.AuthMethod)
+	}
+
+	// Trust a private CA for the Vault server
+	if caFile := os.Getenv("VAULT_CACERT"); caFile != "" {
+		caCert, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read VAULT_CACERT: %w", err)
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to parse VAULT_CACERT")
+		}
+		config.HTTPClient = &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: caCertPool, MinVersion: tls.VersionTLS12},
+			},
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVault is a minimal Vault server with AppRole login, token renewal and one KV v2 secret
+type fakeVault struct {
+	mu       sync.Mutex
+	version  int
+	data     map[string]interface{}
+	token    string // Token the secret endpoint accepts
+	logins   int
+	renewals int
+	lookups  int
+	reads    []string // Raw query of each secret read
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/v1/auth/approle/login":
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["role_id"] != "role" || body["secret_id"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		v.logins++
+		v.token = "login-token"
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": v.token, "lease_duration": 3600, "renewable": true},
+		})
+	case "/v1/auth/token/renew-self":
+		if r.Header.Get("X-Vault-Token") != v.token {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		v.renewals++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": v.token, "lease_duration": 3600, "renewable": true},
+		})
+	case "/v1/auth/token/lookup-self":
+		if r.Header.Get("X-Vault-Token") != v.token {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		v.lookups++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"ttl": 0, "renewable": false},
+		})
+	case "/v1/secret/data/pprox/users":
+		if r.Header.Get("X-Vault-Token") != v.token || r.Header.Get("X-Vault-Namespace") != "team" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+		v.reads = append(v.reads, r.URL.RawQuery)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     v.data,
+				"metadata": map[string]interface{}{"version": v.version},
+			},
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *httptest.Server) {
+	vault := &fakeVault{
+		version: 1,
+		token:   "static-token",
+		data: map[string]interface{}{
+			"alice": "alice-password",
+			"bob":   map[string]interface{}{"password": "bob-password", "read_only": true},
+		},
+	}
+	server := httptest.NewServer(vault)
+	t.Cleanup(server.Close)
+	return vault, server
+}
+
+func TestVaultReadsKVv2Secret(t *testing.T) {
+	vault, server := newFakeVault(t)
+	provider := NewVaultCredentialProvider(&VaultConfig{
+		Address:    server.URL,
+		Namespace:  "team",
+		SecretPath: "secret/pprox/users",
+		AuthMethod: VaultAuthToken,
+		Token:      "static-token",
+	})
+
+	credentials, err := provider.GetCredentials(context.Background())
+	if err != nil {
+		t.Fatalf("GetCredentials() error = %v", err)
+	}
+	if len(credentials) != 2 {
+		t.Fatalf("got %d users, want 2", len(credentials))
+	}
+	if got := credentials["alice"].Password; got != "alice-password" {
+		t.Errorf("alice password = %q, want alice-password", got)
+	}
+	if bob := credentials["bob"]; bob.Password != "bob-password" || !bob.Attributes.ReadOnly {
+		t.Errorf("bob = %+v, want read-only user with password bob-password", bob)
+	}
+	if vault.lookups != 1 {
+		t.Errorf("token looked up %d times, want 1", vault.lookups)
+	}
+}
+
+func TestVaultSkipsUnchangedVersion(t *testing.T) {
+	vault, server := newFakeVault(t)
+	provider := NewVaultCredentialProvider(&VaultConfig{
+		Address:    server.URL,
+		Namespace:  "team",
+		SecretPath: "secret/data/pprox/users",
+		AuthMethod: VaultAuthToken,
+		Token:      "static-token",
+	})
+	ctx := context.Background()
+
+	if _, err := provider.GetCredentials(ctx); err != nil {
+		t.Fatalf("first GetCredentials() error = %v", err)
+	}
+
+	// A version is only skipped once its credentials have been applied
+	if _, err := provider.GetCredentials(ctx); err != nil {
+		t.Fatalf("GetCredentials() for an uncommitted version error = %v", err)
+	}
+	provider.CommitCredentials()
+	if _, err := provider.GetCredentials(ctx); !errors.Is(err, ErrCredentialsNotModified) {
+		t.Fatalf("GetCredentials() for the same version error = %v, want ErrCredentialsNotModified", err)
+	}
+
+	vault.mu.Lock()
+	vault.version = 2
+	vault.data = map[string]interface{}{"carol": "carol-password"}
+	vault.mu.Unlock()
+
+	credentials, err := provider.GetCredentials(ctx)
+	if err != nil {
+		t.Fatalf("GetCredentials() for a new version error = %v", err)
+	}
+	if _, ok := credentials["carol"]; !ok || len(credentials) != 1 {
+		t.Errorf("credentials for version 2 = %v, want only carol", credentials)
+	}
+}
+
+func TestVaultPinnedVersion(t *testing.T) {
+	vault, server := newFakeVault(t)
+	provider := NewVaultCredentialProvider(&VaultConfig{
+		Address:    server.URL,
+		Namespace:  "team",
+		SecretPath: "secret/pprox/users",
+		Version:    7,
+		AuthMethod: VaultAuthToken,
+		Token:      "static-token",
+	})
+
+	if _, err := provider.GetCredentials(context.Background()); err != nil {
+		t.Fatalf("GetCredentials() error = %v", err)
+	}
+	if len(vault.reads) != 1 || vault.reads[0] != "version=7" {
+		t.Errorf("secret reads = %q, want one read of version=7", vault.reads)
+	}
+}
+
+func TestVaultRenewsAndReplacesToken(t *testing.T) {
+	vault, server := newFakeVault(t)
+	vault.token = ""
+	provider := NewVaultCredentialProvider(&VaultConfig{
+		Address:    server.URL,
+		Namespace:  "team",
+		SecretPath: "secret/pprox/users",
+		AuthMethod: VaultAuthAppRole,
+		RoleID:     "role",
+		SecretID:   "secret",
+	})
+	ctx := context.Background()
+
+	if _, err := provider.GetCredentials(ctx); err != nil {
+		t.Fatalf("GetCredentials() error = %v", err)
+	}
+	provider.CommitCredentials()
+	if vault.logins != 1 {
+		t.Fatalf("logged in %d times, want 1", vault.logins)
+	}
+
+	// A token with less than a third of its TTL left is renewed, not replaced
+	provider.tokenExpiry = time.Now().Add(time.Minute)
+	if _, err := provider.GetCredentials(ctx); !errors.Is(err, ErrCredentialsNotModified) {
+		t.Fatalf("GetCredentials() after renewal error = %v, want ErrCredentialsNotModified", err)
+	}
+	if vault.renewals != 1 || vault.logins != 1 {
+		t.Errorf("renewals = %d, logins = %d, want 1 and 1", vault.renewals, vault.logins)
+	}
+	if time.Until(provider.tokenExpiry) < 59*time.Minute {
+		t.Errorf("token expiry not extended by renewal: %v", provider.tokenExpiry)
+	}
+
+	// A revoked token is replaced by logging in again
+	vault.mu.Lock()
+	vault.token = "revoked"
+	vault.mu.Unlock()
+	if _, err := provider.GetCredentials(ctx); !errors.Is(err, ErrCredentialsNotModified) {
+		t.Fatalf("GetCredentials() after revocation error = %v, want ErrCredentialsNotModified", err)
+	}
+	if vault.logins != 2 {
+		t.Errorf("logged in %d times after revocation, want 2", vault.logins)
+	}
+}
+
+func TestVaultDeletedVersion(t *testing.T) {
+	vault, server := newFakeVault(t)
+	vault.data = nil
+	provider := NewVaultCredentialProvider(&VaultConfig{
+		Address:    server.URL,
+		Namespace:  "team",
+		SecretPath: "secret/pprox/users",
+		AuthMethod: VaultAuthToken,
+		Token:      "static-token",
+	})
+
+	if _, err := provider.GetCredentials(context.Background()); err == nil {
+		t.Fatal("GetCredentials() for a deleted version succeeded, want an error")
+	}
+}
+
+func TestVaultReloadsRejectedVersion(t *testing.T) {
+	vault, server := newFakeVault(t)
+	provider := NewVaultCredentialProvider(&VaultConfig{
+		Address:    server.URL,
+		Namespace:  "team",
+		SecretPath: "secret/data/pprox/users",
+		AuthMethod: VaultAuthToken,
+		Token:      "static-token",
+	})
+	cm := NewCredentialManager(provider)
+	ctx := context.Background()
+
+	if err := cm.LoadCredentials(ctx); err != nil {
+		t.Fatalf("LoadCredentials() error = %v", err)
+	}
+
+	// Version 2 fails validation, so it isn't skipped as unchanged later
+	vault.mu.Lock()
+	vault.version = 2
+	vault.data = map[string]interface{}{"carol": map[string]interface{}{"password": "carol-password", "max_connections": -1}}
+	vault.mu.Unlock()
+	for i := 0; i < 2; i++ {
+		if err := cm.LoadCredentials(ctx); err == nil || errors.Is(err, ErrCredentialsNotModified) {
+			t.Fatalf("LoadCredentials() %d of an invalid version error = %v, want a validation error", i+1, err)
+		}
+	}
+	if _, ok := cm.GetAuthConfig().GetUser("alice"); !ok {
+		t.Error("credentials of version 1 not kept")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"time"
+)
+
+// credentialWatchDebounce is how long a watched file has to stay quiet before
+// credentials are reloaded, so that an editor's write-rename-chmod sequence or
+// a Kubernetes secret update results in a single reload.
+const credentialWatchDebounce = 500 * time.Millisecond
+
+// credentialReloads counts reload outcomes; it is served on /debug/vars when
+// METRICS_ADDR is set.
+var credentialReloads = expvar.NewMap("credential_reloads")
+
+// WatchableProvider is implemented by providers whose credentials live in
+// local files, so changes can be picked up without waiting for the next poll.
+type WatchableProvider interface {
+	WatchPaths() []string
+}
+
+// fileWatcher delivers a notification on Events whenever one of the watched
+// files may have changed. Notifications are coalesced, so receivers should
+// re-read the files rather than count events.
+type fileWatcher struct {
+	events chan struct{}
+	close  func() error
+}
+
+func newFileWatcher(close func() error) *fileWatcher {
+	return &fileWatcher{events: make(chan struct{}, 1), close: close}
+}
+
+// Events returns the notification channel
+func (w *fileWatcher) Events() <-chan struct{} {
+	return w.events
+}
+
+// Close stops watching
+func (w *fileWatcher) Close() error {
+	return w.close()
+}
+
+func (w *fileWatcher) notify() {
+	select {
+	case w.events <- struct{}{}:
+	default:
+	}
+}
+
+// StartAutoReload reloads credentials when the provider's files change (for
+// providers implementing WatchableProvider) and every interval if interval is
+// positive. Watching falls back to the interval alone if it can't be set up.
+func (cm *CredentialManager) StartAutoReload(ctx context.Context, interval time.Duration) {
+	if !cm.reloadEnabled {
+		return
+	}
+
+	var watcher *fileWatcher
+	var paths []string
+	if wp, ok := cm.provider.(WatchableProvider); ok && cm.watchEnabled {
+		paths = wp.WatchPaths()
+	}
+	if len(paths) > 0 {
+		w, err := watchFiles(paths)
+		if err != nil {
+			log.Printf("Failed to watch credential files, relying on CREDENTIAL_RELOAD_INTERVAL: %v", err)
+		} else {
+			watcher = w
+		}
+	}
+
+	var events <-chan struct{}
+	if watcher != nil {
+		events = watcher.Events()
+	}
+	if events == nil && interval <= 0 {
+		return
+	}
+
+	go func() {
+		if watcher != nil {
+			defer watcher.Close()
+		}
+		var ticks <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			ticks = ticker.C
+		}
+
+		var debounce *time.Timer
+		var settled <-chan time.Time
+		for {
+			select {
+			case <-events:
+				if debounce == nil {
+					debounce = time.NewTimer(credentialWatchDebounce)
+				} else {
+					debounce.Reset(credentialWatchDebounce)
+				}
+				settled = debounce.C
+			case <-settled:
+				settled = nil
+				cm.reload(ctx, "file change")
+			case <-ticks:
+				cm.reload(ctx, "interval")
+			case <-cm.stopReload:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// reload loads credentials and records the outcome
+func (cm *CredentialManager) reload(ctx context.Context, trigger string) {
+	err := cm.LoadCredentials(ctx)
+	switch {
+	case errors.Is(err, ErrCredentialsNotModified):
+		credentialReloads.Add("unchanged", 1)
+	case err != nil:
+		credentialReloads.Add("failure", 1)
+		log.Printf("Failed to reload credentials (%s): %v", trigger, err)
+	default:
+		credentialReloads.Add("success", 1)
+		log.Printf("Credentials reloaded (%s)", trigger)
+	}
+}
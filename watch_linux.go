@@ -0,0 +1,81 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask selects the events that can replace or rewrite a file: writes
+// closing, atomic renames into place, and links being created or removed.
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM |
+	syscall.IN_CREATE | syscall.IN_DELETE
+
+// k8sDataLink is the symlink Kubernetes atomically swaps when a mounted secret
+// or ConfigMap is updated; the visible files are links through it.
+const k8sDataLink = "..data"
+
+// watchFiles watches paths with inotify. The parent directories are watched
+// rather than the files themselves, because a watch on a file follows its
+// inode and misses the file being replaced by a rename or a symlink swap.
+func watchFiles(paths []string) (*fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify_init1: %w", err)
+	}
+	// A non-blocking descriptor is handled by the runtime poller, so Close
+	// interrupts a pending Read
+	file := os.NewFile(uintptr(fd), "inotify")
+
+	names := make(map[int32]map[string]bool)
+	for _, path := range paths {
+		dir, name := filepath.Split(filepath.Clean(path))
+		if dir == "" {
+			dir = "."
+		}
+		wd, err := syscall.InotifyAddWatch(fd, dir, inotifyMask)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+		if names[int32(wd)] == nil {
+			names[int32(wd)] = make(map[string]bool)
+		}
+		names[int32(wd)][name] = true
+	}
+
+	w := newFileWatcher(file.Close)
+	go w.readInotify(file, names)
+	return w, nil
+}
+
+// readInotify turns inotify events for the watched names into notifications
+func (w *fileWatcher) readInotify(file *os.File, names map[int32]map[string]bool) {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			end := start + int(event.Len)
+			if end > n {
+				break
+			}
+			name := strings.TrimRight(string(buf[start:end]), "\x00")
+			offset = end
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 || names[event.Wd][name] || name == k8sDataLink {
+				w.notify()
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// credentialDocument returns a credential file with a single user
+func credentialDocument(username string) []byte {
+	return []byte(fmt.Sprintf(`{"users":[{"username":%q,"password":"secret"}]}`, username))
+}
+
+// startWatchTest loads the credential file at path and watches it, counting
+// the reloads that apply new credentials
+func startWatchTest(t *testing.T, path string) (*CredentialManager, *atomic.Int32) {
+	t.Helper()
+	cm := NewCredentialManager(NewFileCredentialProvider(path, ""))
+	if err := cm.LoadCredentials(context.Background()); err != nil {
+		t.Fatalf("LoadCredentials() error = %v", err)
+	}
+
+	reloads := &atomic.Int32{}
+	cm.OnReload(func(previous, current *AuthConfig) {
+		reloads.Add(1)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cm.StartAutoReload(ctx, 0)
+	return cm, reloads
+}
+
+// waitReloads waits for want reloads, then for another debounce period to
+// make sure no further reload follows
+func waitReloads(t *testing.T, reloads *atomic.Int32, want int32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for reloads.Load() < want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(2 * credentialWatchDebounce)
+	if n := reloads.Load(); n != want {
+		t.Fatalf("reloaded %d times, want %d", n, want)
+	}
+}
+
+// wantUser fails unless the current credentials contain exactly the user
+func wantUser(t *testing.T, cm *CredentialManager, username, removed string) {
+	t.Helper()
+	if _, ok := cm.GetAuthConfig().GetUser(username); !ok {
+		t.Errorf("user %s not loaded", username)
+	}
+	if _, ok := cm.GetAuthConfig().GetUser(removed); ok {
+		t.Errorf("user %s still loaded", removed)
+	}
+}
+
+func TestWatchKubernetesSymlinkSwap(t *testing.T) {
+	// Kubernetes mounts secrets as links through ..data into a timestamped directory
+	dir := t.TempDir()
+	writeVersion := func(name, username string) {
+		if err := os.Mkdir(filepath.Join(dir, name), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name, "credentials.json"), credentialDocument(username), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeVersion("..2026_10_18_01", "alice")
+	if err := os.Symlink("..2026_10_18_01", filepath.Join(dir, k8sDataLink)); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "credentials.json")
+	if err := os.Symlink(filepath.Join(k8sDataLink, "credentials.json"), path); err != nil {
+		t.Fatal(err)
+	}
+	cm, reloads := startWatchTest(t, path)
+
+	// The update creates a new directory, swaps ..data to it with a rename
+	// and removes the old directory
+	writeVersion("..2026_10_18_02", "bob")
+	if err := os.Symlink("..2026_10_18_02", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, k8sDataLink)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "..2026_10_18_01")); err != nil {
+		t.Fatal(err)
+	}
+
+	waitReloads(t, reloads, 1)
+	wantUser(t, cm, "bob", "alice")
+}
+
+func TestWatchAtomicRename(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "credentials.json")
+	if err := os.WriteFile(path, credentialDocument("alice"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cm, reloads := startWatchTest(t, path)
+
+	// Editors and config management write a temporary file and rename it into place
+	tmp := filepath.Join(dir, ".credentials.json.swp")
+	if err := os.WriteFile(tmp, credentialDocument("bob"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+
+	waitReloads(t, reloads, 1)
+	wantUser(t, cm, "bob", "alice")
+}
+
+func TestWatchDebouncesBursts(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "credentials.json")
+	if err := os.WriteFile(path, credentialDocument("alice"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cm, reloads := startWatchTest(t, path)
+
+	// Writes within the debounce period result in one reload of the final content
+	for _, username := range []string{"bob", "carol", "dave"} {
+		if err := os.WriteFile(path, credentialDocument(username), 0600); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(credentialWatchDebounce / 5)
+	}
+	waitReloads(t, reloads, 1)
+	wantUser(t, cm, "dave", "alice")
+
+	// Touching the file without changing it doesn't apply anything
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, credentialDocument("dave"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	waitReloads(t, reloads, 1)
+}
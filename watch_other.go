@@ -0,0 +1,55 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// watchPollInterval is how often watched files are checked without inotify
+const watchPollInterval = 2 * time.Second
+
+// watchFiles polls paths for changes. Stat follows symlinks, so a replaced
+// file or a swapped Kubernetes ..data link shows up as a different file.
+func watchFiles(paths []string) (*fileWatcher, error) {
+	done := make(chan struct{})
+	w := newFileWatcher(func() error {
+		close(done)
+		return nil
+	})
+
+	go func() {
+		last := make([]os.FileInfo, len(paths))
+		for i, path := range paths {
+			last[i], _ = os.Stat(path)
+		}
+
+		ticker := time.NewTicker(watchPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for i, path := range paths {
+					info, _ := os.Stat(path)
+					if fileChanged(last[i], info) {
+						w.notify()
+					}
+					last[i] = info
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return w, nil
+}
+
+// fileChanged reports whether two stat results describe different contents
+func fileChanged(before, after os.FileInfo) bool {
+	if before == nil || after == nil {
+		return before != after
+	}
+	return !os.SameFile(before, after) || !before.ModTime().Equal(after.ModTime()) || before.Size() != after.Size()
+}